@@ -0,0 +1,58 @@
+package main
+
+import (
+	"enpeeem/storage"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/alitto/pond"
+	"github.com/schollz/progressbar/v3"
+)
+
+// migrateCAS converts the tarballs of all packages to links to blobs, removing
+// duplicates.
+func migrateCAS(store *storage.FileStore, pkgthreads int) int {
+	pkgs, err := store.Packages()
+	if err != nil {
+		slog.Error("failed to list packages", "cause", err)
+		return 1
+	}
+	var bar *progressbar.ProgressBar
+	if progress {
+		bar = progressbar.NewOptions(len(pkgs), progressbar.OptionSetDescription("migrating packages"), progressbar.OptionSetWriter(os.Stdout), progressbar.OptionShowCount(), progressbar.OptionFullWidth())
+	} else {
+		bar = progressbar.DefaultSilent(int64(len(pkgs)))
+	}
+	exitCode := 0
+	deduplicated, saved := 0, int64(0)
+	mux := sync.Mutex{}
+	pool := pond.New(pkgthreads, 0)
+	for _, pkg := range pkgs {
+		pool.Submit(func() {
+			n, size, err := store.MigrateToCAS(pkg)
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				slog.Error("error migrating package", "cause", err, "package", pkg.String())
+				exitCode = 1
+			}
+			deduplicated += n
+			saved += size
+			bar.Add(1)
+		})
+	}
+	pool.StopAndWait()
+	slog.Info("migrated to content-addressable storage", "packages", len(pkgs), "deduplicated", deduplicated, "saved_bytes", saved)
+	return exitCode
+}
+
+func collectGarbage(store *storage.FileStore) int {
+	removed, freed, err := store.CollectGarbage()
+	if err != nil {
+		slog.Error("error removing unreferenced blobs", "cause", err)
+		return 1
+	}
+	slog.Info("removed unreferenced blobs", "blobs", removed, "freed_bytes", freed)
+	return 0
+}
@@ -0,0 +1,267 @@
+package changes
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the log.
+const (
+	ActionStash     = "stash"
+	ActionPublish   = "publish"
+	ActionUnpublish = "unpublish"
+	ActionUpdate    = "update"
+)
+
+// checkpointInterval is the number of changes between the file offsets kept in
+// memory, reading changes starts at the closest offset instead of the beginning.
+const checkpointInterval = 1000
+
+// Change is a single entry in the changes log. ID is the package URI, for
+// example registry.npmjs.org/@types/react.
+type Change struct {
+	Seq      int64     `json:"seq"`
+	ID       string    `json:"id"`
+	Registry string    `json:"registry"`
+	Package  string    `json:"package"`
+	Version  string    `json:"version,omitempty"`
+	Action   string    `json:"action"`
+	Time     time.Time `json:"time"`
+}
+
+// Log is an append-only log of changes with increasing sequence numbers,
+// saved as one JSON object per line.
+type Log struct {
+	file        string
+	mux         sync.Mutex
+	seq         int64
+	changed     chan struct{}
+	checkpoints []checkpoint
+}
+
+// checkpoint is the offset of a change in the log file.
+type checkpoint struct {
+	seq    int64
+	offset int64
+}
+
+// Open opens the log file, creating it if it doesn't exist. A new log is filled
+// with a stash change for every tarball in store, so consumers starting from
+// the beginning see all packages.
+func Open(file string, store storage.Store) (*Log, error) {
+	l := &Log{file: file, changed: make(chan struct{})}
+	_, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, l.backfill(store)
+	}
+	if err != nil {
+		return l, err
+	}
+	err = l.scan(0, func(c Change, offset int64) bool {
+		l.seq = c.Seq
+		l.checkpoint(c.Seq, offset)
+		return true
+	})
+	return l, err
+}
+
+// checkpoint keeps the offset of a change if it's at least checkpointInterval
+// changes after the last checkpoint. The lock must be held when the log is in use.
+func (l *Log) checkpoint(seq, offset int64) {
+	if n := len(l.checkpoints); n == 0 || seq >= l.checkpoints[n-1].seq+checkpointInterval {
+		l.checkpoints = append(l.checkpoints, checkpoint{seq: seq, offset: offset})
+	}
+}
+
+// offset returns the offset of the closest change with a sequence number of at
+// most seq.
+func (l *Log) offset(seq int64) int64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	i := sort.Search(len(l.checkpoints), func(i int) bool {
+		return l.checkpoints[i].seq > seq
+	})
+	if i == 0 {
+		return 0
+	}
+	return l.checkpoints[i-1].offset
+}
+
+func (l *Log) backfill(store storage.Store) error {
+	if err := os.MkdirAll(filepath.Dir(l.file), 0755); err != nil {
+		return err
+	}
+	pkgs, err := store.Packages()
+	if err != nil {
+		return err
+	}
+	changes := []Change{}
+	for _, pkg := range pkgs {
+		tarballs, err := store.Tarballs(pkg)
+		if err != nil {
+			return err
+		}
+		for _, tarball := range tarballs {
+			changes = append(changes, Change{Registry: pkg.Registry, Package: pkg.FullName(), Version: tarball.Version(), Action: ActionStash})
+		}
+	}
+	slog.Info("creating changes log", "file", l.file, "changes", len(changes))
+	return l.Append(changes...)
+}
+
+// Append adds changes to the log, setting their sequence number, id and time.
+func (l *Log) Append(changes ...Change) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	f, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	w := bufio.NewWriter(f)
+	seq := l.seq
+	checkpoints := []checkpoint{}
+	for _, c := range changes {
+		seq++
+		c.Seq = seq
+		c.ID = packageURI(c.Registry, c.Package)
+		if c.Time.IsZero() {
+			c.Time = time.Now().UTC()
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, checkpoint{seq: seq, offset: offset})
+		offset += int64(len(data)) + 1
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, cp := range checkpoints {
+		l.checkpoint(cp.seq, cp.offset)
+	}
+	l.seq = seq
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+// Seq returns the sequence number of the last change.
+func (l *Log) Seq() int64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.seq
+}
+
+// Since returns up to limit changes after the given sequence number, all changes
+// are returned if limit is zero.
+func (l *Log) Since(since int64, limit int) ([]Change, error) {
+	changes := []Change{}
+	err := l.scan(l.offset(since+1), func(c Change, _ int64) bool {
+		if c.Seq > since {
+			changes = append(changes, c)
+		}
+		return limit <= 0 || len(changes) < limit
+	})
+	return changes, err
+}
+
+// Wait returns changes after the given sequence number, waiting up to timeout for
+// new changes if there are none.
+func (l *Log) Wait(ctx context.Context, since int64, limit int, timeout time.Duration) ([]Change, error) {
+	l.mux.Lock()
+	changed := l.changed
+	seq := l.seq
+	l.mux.Unlock()
+	if seq <= since {
+		select {
+		case <-changed:
+		case <-time.After(timeout):
+		case <-ctx.Done():
+		}
+	}
+	return l.Since(since, limit)
+}
+
+// scan calls fn for each change, and it's offset in the file, in order starting
+// at offset until fn returns false.
+func (l *Log) scan(offset int64, fn func(Change, int64) bool) error {
+	f, err := os.Open(l.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		lineOffset := offset
+		offset += int64(len(line)) + 1
+		c := Change{}
+		if err := json.Unmarshal(line, &c); err != nil {
+			// a line can be incomplete if enpeeem was stopped while writing
+			slog.Warn("skipping invalid line in changes log", "file", l.file, "cause", err)
+			continue
+		}
+		if !fn(c, lineOffset) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// Record appends a change for webhook events that change the stash, other events
+// are ignored. Errors are logged since events can't fail.
+func (l *Log) Record(e webhook.Event) {
+	action := ""
+	switch e.Event {
+	case webhook.EventStash:
+		action = ActionStash
+	case webhook.EventPublish:
+		action = ActionPublish
+	case webhook.EventUnpublish:
+		action = ActionUnpublish
+	case webhook.EventIndex, webhook.EventDistTag, webhook.EventDistTagRm:
+		action = ActionUpdate
+	default:
+		return
+	}
+	version := e.Version
+	if action == ActionUpdate {
+		version = ""
+	}
+	if err := l.Append(Change{Registry: e.Registry, Package: e.Name, Version: version, Action: action}); err != nil {
+		slog.Error("failed to record change", "pkg", e.Name, "action", action, "cause", err)
+	}
+}
+
+func packageURI(registry, pkg string) string {
+	return registry + "/" + pkg
+}
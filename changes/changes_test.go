@@ -0,0 +1,111 @@
+package changes
+
+import (
+	"context"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewFileStore(dir, dir)
+	pkg := storage.Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "react"}
+	if err := store.PutTarball(storage.NewTarball(pkg, "react-18.0.0.tgz"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, ".enpeeem", "changes.jsonl")
+	log, err := Open(file, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Seq() != 1 {
+		t.Fatalf("expected 1 backfilled change but got %d", log.Seq())
+	}
+
+	log.Record(webhook.Event{Event: webhook.EventPublish, Registry: "registry.npmjs.org", Name: "left-pad", Version: "1.0.0"})
+	log.Record(webhook.Event{Event: webhook.EventDenied, Registry: "registry.npmjs.org", Name: "left-pad", Version: "1.0.0"})
+	log.Record(webhook.Event{Event: webhook.EventIndex, Registry: "registry.npmjs.org", Name: "left-pad", Version: "1.0.0"})
+
+	// reopening keeps the sequence and doesn't backfill again
+	log, err = Open(file, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := log.Since(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type Expected struct {
+		ID      string
+		Version string
+		Action  string
+	}
+	expected := []Expected{
+		{ID: "registry.npmjs.org/@types/react", Version: "18.0.0", Action: ActionStash},
+		{ID: "registry.npmjs.org/left-pad", Version: "1.0.0", Action: ActionPublish},
+		{ID: "registry.npmjs.org/left-pad", Version: "", Action: ActionUpdate},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes but got %d", len(expected), len(changes))
+	}
+	for i, e := range expected {
+		c := changes[i]
+		if c.Seq != int64(i+1) || c.ID != e.ID || c.Version != e.Version || c.Action != e.Action {
+			t.Errorf("expected change %d to be %v but got %v", i+1, e, c)
+		}
+	}
+	if changes, _ := log.Since(1, 1); len(changes) != 1 || changes[0].Seq != 2 {
+		t.Errorf("expected only change 2 but got %v", changes)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		log.Append(Change{Registry: "registry.npmjs.org", Package: "react", Version: "19.0.0", Action: ActionStash})
+	}()
+	changes, err = log.Wait(context.Background(), 3, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Seq != 4 {
+		t.Errorf("expected to wait for change 4 but got %v", changes)
+	}
+	if changes, _ := log.Wait(context.Background(), 4, 0, time.Millisecond); len(changes) != 0 {
+		t.Errorf("expected no changes after timeout but got %v", changes)
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "changes.jsonl")
+	log, err := Open(file, storage.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []Change{}
+	for range 2500 {
+		changes = append(changes, Change{Registry: "registry.npmjs.org", Package: "left-pad", Action: ActionUpdate})
+	}
+	if err := log.Append(changes...); err != nil {
+		t.Fatal(err)
+	}
+	// checkpoints are rebuilt when reopening the log
+	reopened, err := Open(file, storage.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*Log{log, reopened} {
+		if len(l.checkpoints) != 3 {
+			t.Errorf("expected 3 checkpoints but got %v", l.checkpoints)
+		}
+		changes, err := l.Since(2100, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 3 || changes[0].Seq != 2101 || changes[2].Seq != 2103 {
+			t.Errorf("expected changes 2101 to 2103 but got %v", changes)
+		}
+	}
+}
@@ -2,17 +2,36 @@ package config
 
 import (
 	"context"
+	"enpeeem/changes"
+	"enpeeem/cooldown"
+	"enpeeem/fetch"
+	"enpeeem/policy"
+	"enpeeem/reindex"
 	"enpeeem/storage"
+	"enpeeem/verify"
+	"enpeeem/watch"
+	"enpeeem/webhook"
 	"net/http"
 	"text/template"
+	"time"
 )
 
 type Config struct {
 	Registry    string
 	Store       storage.Store
 	ProxyStash  bool
-	FetchAll    bool
 	URLTemplate *template.Template
+	Snapshot    time.Time
+	Cooldown    *cooldown.Cooldown
+	Policy      *policy.Engine
+	Webhooks    *webhook.Dispatcher
+	Changes     *changes.Log
+	Watchlist   *watch.Watchlist
+	Fetch       *fetch.Queue
+	SQLIndex    *storage.SQLIndex
+	AdminToken  string
+	Reindex     *reindex.Indexer
+	Verify      *verify.Verifier
 }
 
 type cfgKey string
@@ -20,12 +39,11 @@ type cfgKey string
 const key cfgKey = "config"
 
 // default template https://{{.Package.Registry}}/{{.Package.Name}}/{{.Package.Scope}}/-/{{.Name}}
-func New(store storage.Store, registry, urltemplate string, proxystash, fetchall bool) (Config, error) {
+func New(store storage.Store, registry, urltemplate string, proxystash bool) (Config, error) {
 	cfg := Config{
 		Registry:   registry,
 		Store:      store,
 		ProxyStash: proxystash,
-		FetchAll:   fetchall,
 	}
 	if urltemplate != "" {
 		tmpl, err := template.New("rewrite").Parse(urltemplate)
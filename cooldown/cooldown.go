@@ -0,0 +1,227 @@
+package cooldown
+
+import (
+	"encoding/json"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AssetName = "cooldown.json"
+
+var ErrCooldown = errors.New("version is in cooldown")
+
+// Version is a version removed from package metadata since it was published
+// too recently.
+type Version struct {
+	Package   string    `json:"package"`
+	Version   string    `json:"version"`
+	Published time.Time `json:"published"`
+	Until     time.Time `json:"until"`
+}
+
+// Cooldown keeps versions published too recently out of the stash. Versions in
+// cooldown can be released early, releases are saved with the package in the store.
+// Versions held back are saved to the state file so they are still listed after
+// a restart.
+type Cooldown struct {
+	Default   time.Duration
+	Overrides map[string]time.Duration
+	store     storage.Store
+	stateFile string
+	mux       sync.Mutex
+	versions  map[string]Version
+}
+
+// New creates a cooldown loading the versions held back from stateFile, if it
+// exists. Versions are only kept in memory if stateFile is empty.
+func New(store storage.Store, def time.Duration, overrides map[string]time.Duration, stateFile string) (*Cooldown, error) {
+	c := &Cooldown{
+		Default:   def,
+		Overrides: overrides,
+		store:     store,
+		stateFile: stateFile,
+		versions:  map[string]Version{},
+	}
+	if stateFile == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	versions := []Version{}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return c, fmt.Errorf("error parsing cooldown state %s: %w", stateFile, err)
+	}
+	for _, v := range versions {
+		c.versions[v.Package+"@"+v.Version] = v
+	}
+	return c, nil
+}
+
+// ParseOverrides parses a comma separated list of scopes or package names and their
+// cooldown in days, example @internal=0,react=14.
+func ParseOverrides(s string) (map[string]time.Duration, error) {
+	overrides := map[string]time.Duration{}
+	for _, override := range strings.Split(s, ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		name, days, found := strings.Cut(override, "=")
+		if !found {
+			return overrides, fmt.Errorf("invalid cooldown override %s, expected <name>=<days>", override)
+		}
+		d, err := strconv.Atoi(days)
+		if err != nil {
+			return overrides, fmt.Errorf("invalid cooldown override %s: %w", override, err)
+		}
+		overrides[strings.TrimSpace(name)] = time.Duration(d) * 24 * time.Hour
+	}
+	return overrides, nil
+}
+
+// Duration returns the cooldown for a package. Overrides for the package name take
+// precedence over overrides for the scope.
+func (c *Cooldown) Duration(pkg storage.Package) time.Duration {
+	if d, found := c.Overrides[pkg.FullName()]; found {
+		return d
+	}
+	if d, found := c.Overrides[pkg.Scope]; pkg.Scope != "" && found {
+		return d
+	}
+	return c.Default
+}
+
+// Allowed returns true if the version was published long enough ago, or if it
+// has been released early. Versions with unknown publish time are allowed.
+func (c *Cooldown) Allowed(pkg storage.Package, version string, published time.Time, found bool) (bool, error) {
+	if !found {
+		return true, nil
+	}
+	until := published.Add(c.Duration(pkg))
+	if time.Now().After(until) {
+		return true, nil
+	}
+	released, err := c.released(pkg)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(released, version) {
+		return true, nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	v := Version{Package: pkg.String(), Version: version, Published: published, Until: until}
+	if c.versions[key(pkg, version)] != v {
+		c.versions[key(pkg, version)] = v
+		if err := c.save(); err != nil {
+			slog.Error("failed to save cooldown state", "cause", err)
+		}
+	}
+	return false, nil
+}
+
+// Filter removes versions in cooldown from raw package metadata.
+func (c *Cooldown) Filter(pkg storage.Package, data []byte) ([]byte, error) {
+	var err error
+	filtered, ferr := storage.FilterVersions(data, func(version string, published time.Time, found bool) bool {
+		allowed, aerr := c.Allowed(pkg, version, published, found)
+		if aerr != nil {
+			err = aerr
+		}
+		return allowed
+	})
+	if ferr != nil {
+		return data, ferr
+	}
+	return filtered, err
+}
+
+// Versions returns all versions currently in cooldown.
+func (c *Cooldown) Versions() []Version {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	versions := []Version{}
+	expired := false
+	for k, v := range c.versions {
+		if time.Now().After(v.Until) {
+			delete(c.versions, k)
+			expired = true
+			continue
+		}
+		versions = append(versions, v)
+	}
+	if expired {
+		if err := c.save(); err != nil {
+			slog.Error("failed to save cooldown state", "cause", err)
+		}
+	}
+	slices.SortFunc(versions, func(a, b Version) int {
+		return a.Until.Compare(b.Until)
+	})
+	return versions
+}
+
+// Release allows a version in cooldown to be fetched before the cooldown ends.
+func (c *Cooldown) Release(pkg storage.Package, version string) error {
+	released, err := c.released(pkg)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(released, version) {
+		released = append(released, version)
+	}
+	if err := storage.PutJSONAsset(c.store, pkg, AssetName, released); err != nil {
+		return err
+	}
+	slog.Info("version released from cooldown", "pkg", pkg.String(), "version", version)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.versions, key(pkg, version))
+	return c.save()
+}
+
+// save writes the versions in cooldown to the state file, c.mux must be held.
+func (c *Cooldown) save() error {
+	if c.stateFile == "" {
+		return nil
+	}
+	versions := []Version{}
+	for _, v := range c.versions {
+		versions = append(versions, v)
+	}
+	slices.SortFunc(versions, func(a, b Version) int {
+		return strings.Compare(a.Package+"@"+a.Version, b.Package+"@"+b.Version)
+	})
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.stateFile, data, 0644)
+}
+
+func (c *Cooldown) released(pkg storage.Package) ([]string, error) {
+	released := []string{}
+	err := storage.GetJSONAsset(c.store, pkg, AssetName, &released)
+	return released, err
+}
+
+func key(pkg storage.Package, version string) string {
+	return pkg.String() + "@" + version
+}
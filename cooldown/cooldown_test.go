@@ -0,0 +1,97 @@
+package cooldown
+
+import (
+	"encoding/json"
+	"enpeeem/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	overrides, err := ParseOverrides("@internal=0, react=14,@types/react=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(nil, 7*24*time.Hour, overrides, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Test struct {
+		Package  storage.Package
+		Expected time.Duration
+	}
+	tests := []Test{
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "", Name: "lodash"}, Expected: 7 * 24 * time.Hour},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "", Name: "react"}, Expected: 14 * 24 * time.Hour},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "@internal", Name: "ui"}, Expected: 0},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "react"}, Expected: 24 * time.Hour},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "node"}, Expected: 7 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if actual := c.Duration(test.Package); actual != test.Expected {
+			t.Errorf("%s: expected %v but got %v", test.Package.String(), test.Expected, actual)
+		}
+	}
+
+	if _, err := ParseOverrides("react"); err == nil {
+		t.Error("expected error parsing override without days")
+	}
+}
+
+func TestFilterAndRelease(t *testing.T) {
+	store := storage.NewFileStore(t.TempDir(), t.TempDir())
+	pkg := storage.Package{Registry: "registry.npmjs.org", Scope: "", Name: "react"}
+	stateFile := filepath.Join(t.TempDir(), "cooldown.json")
+	c, err := New(store, 7*24*time.Hour, map[string]time.Duration{}, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := time.Now().Add(-time.Hour).UTC().Format(storage.TimeLayout)
+	data := []byte(`{
+		"dist-tags": {"latest": "2.0.0"},
+		"time": {"1.0.0": "2020-01-01T00:00:00.000Z", "2.0.0": "` + recent + `"},
+		"versions": {"1.0.0": {}, "2.0.0": {}}
+	}`)
+
+	latest := func(data []byte) string {
+		doc := storage.PackageMetadata{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.DistTags["latest"]
+	}
+
+	filtered, err := c.Filter(pkg, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := latest(filtered); actual != "1.0.0" {
+		t.Errorf("expected latest to be 1.0.0 but got %s", actual)
+	}
+	if versions := c.Versions(); len(versions) != 1 || versions[0].Version != "2.0.0" {
+		t.Errorf("expected version 2.0.0 in cooldown but got %v", versions)
+	}
+	restarted, err := New(store, 7*24*time.Hour, map[string]time.Duration{}, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := restarted.Versions(); len(versions) != 1 || versions[0].Version != "2.0.0" {
+		t.Errorf("expected version 2.0.0 in cooldown after restart but got %v", versions)
+	}
+
+	if err := c.Release(pkg, "2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = c.Filter(pkg, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := latest(filtered); actual != "2.0.0" {
+		t.Errorf("expected latest to be 2.0.0 after release but got %s", actual)
+	}
+	if versions := c.Versions(); len(versions) != 0 {
+		t.Errorf("expected no versions in cooldown but got %v", versions)
+	}
+}
@@ -0,0 +1,209 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// maxEdits is the number of edits after which two files are shown as completely
+// replaced, it limits the memory used to diff very different files.
+const maxEdits = 2000
+
+type edit struct {
+	kind byte // ' ' for equal, '-' for removed and '+' for added lines
+	a, b int  // the line in a and b before the edit
+}
+
+// SplitLines splits text into lines keeping the newlines, so a last line without
+// newline differs from the same line with newline.
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Equal compares lines exactly.
+func Equal(x, y string) bool {
+	return x == y
+}
+
+// EqualIgnoreWhitespace compares lines ignoring all whitespace, like git diff -w.
+func EqualIgnoreWhitespace(x, y string) bool {
+	return stripSpace(x) == stripSpace(y)
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// edits returns the shortest edit script turning a into b using the Myers
+// algorithm.
+func edits(a, b []string, equal func(x, y string) bool) []edit {
+	// common prefix and suffix are equal lines without running the algorithm
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	script := []edit{}
+	for i := 0; i < prefix; i++ {
+		script = append(script, edit{' ', i, i})
+	}
+	script = append(script, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix, equal)...)
+	for i := suffix; i > 0; i-- {
+		script = append(script, edit{' ', len(a) - i, len(b) - i})
+	}
+	return script
+}
+
+func myers(a, b []string, offA, offB int, equal func(x, y string) bool) []edit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace has the part of v used by each step to find the edits back
+	trace := [][]int{}
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int{}, v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			x := v[off+k-1] + 1
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			}
+			y := x - k
+			for x < n && y < m && equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		script := []edit{}
+		for i := range a {
+			script = append(script, edit{'-', offA + i, offB})
+		}
+		for i := range b {
+			script = append(script, edit{'+', offA + n, offB + i})
+		}
+		return script
+	}
+
+	reversed := []edit{}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+		}
+		prevX := prev(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, edit{' ', offA + x, offB + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, edit{'+', offA + x, offB + prevY})
+			} else {
+				reversed = append(reversed, edit{'-', offA + prevX, offB + y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	script := make([]edit, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		script = append(script, reversed[i])
+	}
+	return script
+}
+
+// Unified returns the hunks of the unified diff of a and b with context lines
+// around changes, an empty string if there are no changes.
+func Unified(a, b []string, context int, equal func(x, y string) bool) string {
+	script := edits(a, b, equal)
+	out := strings.Builder{}
+	for start := 0; start < len(script); {
+		// find the next change and the end of its hunk
+		first := start
+		for first < len(script) && script[first].kind == ' ' {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		last := first
+		for i := first; i < len(script); i++ {
+			if script[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+		from := max(first-context, start)
+		to := min(last+context+1, len(script))
+		writeHunk(&out, a, b, script[from:to])
+		start = to
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, a, b []string, hunk []edit) {
+	lenA, lenB := 0, 0
+	for _, e := range hunk {
+		if e.kind != '+' {
+			lenA++
+		}
+		if e.kind != '-' {
+			lenB++
+		}
+	}
+	startA, startB := hunk[0].a, hunk[0].b
+	if lenA > 0 {
+		startA++
+	}
+	if lenB > 0 {
+		startB++
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(startA, lenA), hunkRange(startB, lenB))
+	for _, e := range hunk {
+		line := ""
+		switch e.kind {
+		case '+':
+			line = b[e.b]
+		default:
+			line = a[e.a]
+		}
+		out.WriteByte(e.kind)
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, length int) string {
+	if length == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
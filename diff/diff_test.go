@@ -0,0 +1,157 @@
+package diff
+
+import (
+	"enpeeem/storage"
+	"enpeeem/storage/storagetest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	type Test struct {
+		Name     string
+		A        string
+		B        string
+		Context  int
+		Equal    func(x, y string) bool
+		Expected string
+	}
+	tests := []Test{
+		{"equal", "a\nb\n", "a\nb\n", 3, Equal, ""},
+		{"change", "a\nb\nc\n", "a\nx\nc\n", 3, Equal, "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"no context", "a\nb\nc\n", "a\nx\nc\n", 0, Equal, "@@ -2 +2 @@\n-b\n+x\n"},
+		{"added file", "", "a\nb\n", 3, Equal, "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"removed lines", "a\nb\nc\n", "a\n", 3, Equal, "@@ -1,3 +1 @@\n a\n-b\n-c\n"},
+		{"insert", "a\nb\n", "a\nx\nb\n", 0, Equal, "@@ -1,0 +2 @@\n+x\n"},
+		{"hunks", "1\n2\n3\n4\n5\n6\n7\n8\n", "x\n2\n3\n4\n5\n6\n7\ny\n", 1, Equal, "@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+y\n"},
+		{"merged hunks", "1\n2\n3\n4\n", "x\n2\n3\ny\n", 1, Equal, "@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n-4\n+y\n"},
+		{"newline at end", "a", "a\n", 3, Equal, "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		{"whitespace", "if (a) {\n  b()\n}\n", "if (a) {\n    b();\n}\n", 3, EqualIgnoreWhitespace, "@@ -1,3 +1,3 @@\n if (a) {\n-  b()\n+    b();\n }\n"},
+		{"only whitespace", "a  b\n", "a b", 3, EqualIgnoreWhitespace, ""},
+	}
+	for _, test := range tests {
+		actual := Unified(SplitLines(test.A), SplitLines(test.B), test.Context, test.Equal)
+		if actual != test.Expected {
+			t.Errorf("%s: expected %q but got %q", test.Name, test.Expected, actual)
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+	removed, added, equal := 0, 0, 0
+	for _, e := range edits(a, b, Equal) {
+		switch e.kind {
+		case '-':
+			removed++
+		case '+':
+			added++
+		default:
+			equal++
+		}
+	}
+	// the shortest edit script has 5 edits
+	if removed+added != 5 || equal != 4 {
+		t.Errorf("expected 5 edits and 4 equal lines but got %d %d", removed+added, equal)
+	}
+}
+
+func TestTarballs(t *testing.T) {
+	pkg := storage.Package{Registry: "registry.npmjs.org", Name: "x"}
+	from := storagetest.NewTarball(t, map[string]string{
+		"package/package.json": `{"dependencies": {"a": "^1.0.0", "b": "^1.0.0"}, "scripts": {"test": "jest"}}`,
+		"package/index.js":     "module.exports = 1\n",
+		"package/lib/old.js":   "old\n",
+		"package/README.md":    "# x\n",
+	})
+	to := storagetest.NewTarball(t, map[string]string{
+		"package/package.json": `{"dependencies": {"a": "^2.0.0", "c": "1.0.0"}, "scripts": {"test": "jest", "postinstall": "node setup.js"}}`,
+		"package/index.js":     "module.exports = 2\n",
+		"package/lib/new.js":   "new\n",
+		"package/README.md":    "#  x\n",
+		"package/logo.png":     "\x89PNG\x00",
+	})
+	type Test struct {
+		Name     string
+		Options  Options
+		Expected Summary
+		Patch    []string
+	}
+	deps := []DependencyChange{
+		{Type: "dependencies", Name: "a", From: "^1.0.0", To: "^2.0.0"},
+		{Type: "dependencies", Name: "b", From: "^1.0.0"},
+		{Type: "dependencies", Name: "c", To: "1.0.0"},
+	}
+	scripts := []ScriptChange{{Name: "postinstall", To: "node setup.js"}}
+	tests := []Test{
+		{
+			Name:    "all",
+			Options: Options{Context: 3},
+			Expected: Summary{
+				Added:            []string{"lib/new.js", "logo.png"},
+				Removed:          []string{"lib/old.js"},
+				Changed:          []string{"README.md", "index.js", "package.json"},
+				Dependencies:     deps,
+				LifecycleScripts: scripts,
+			},
+			Patch: []string{
+				"diff --git a/index.js b/index.js\n--- a/index.js\n+++ b/index.js\n@@ -1 +1 @@\n-module.exports = 1\n+module.exports = 2\n",
+				"diff --git a/lib/new.js b/lib/new.js\nnew file mode 100644\n--- /dev/null\n+++ b/lib/new.js\n@@ -0,0 +1 @@\n+new\n",
+				"diff --git a/lib/old.js b/lib/old.js\ndeleted file mode 100644\n--- a/lib/old.js\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+				"diff --git a/logo.png b/logo.png\nnew file mode 100644\nBinary files /dev/null and b/logo.png differ\n",
+			},
+		},
+		{
+			Name:    "ignore whitespace and paths",
+			Options: Options{IgnoreWhitespace: true, Paths: []string{"lib", "*.md"}},
+			Expected: Summary{
+				Added:            []string{"lib/new.js"},
+				Removed:          []string{"lib/old.js"},
+				Changed:          []string{},
+				Dependencies:     deps,
+				LifecycleScripts: scripts,
+			},
+		},
+	}
+	for _, test := range tests {
+		summary, patch, err := Tarballs(storage.NewTarball(pkg, "x-1.0.0.tgz"), storage.NewTarball(pkg, "x-2.0.0.tgz"), from, to, test.Options)
+		if err != nil {
+			t.Errorf("%s: expected nil but got %v", test.Name, err)
+		}
+		if !reflect.DeepEqual(summary, test.Expected) {
+			t.Errorf("%s: expected %+v but got %+v", test.Name, test.Expected, summary)
+		}
+		for _, expected := range test.Patch {
+			if !strings.Contains(patch, expected) {
+				t.Errorf("%s: expected %q in %q", test.Name, expected, patch)
+			}
+		}
+		if test.Options.IgnoreWhitespace && strings.Contains(patch, "README.md") {
+			t.Errorf("%s: expected no README.md changes in %q", test.Name, patch)
+		}
+	}
+}
+
+func TestOversizedFiles(t *testing.T) {
+	maxContentSize := storage.MaxContentSize
+	storage.MaxContentSize = 10
+	t.Cleanup(func() {
+		storage.MaxContentSize = maxContentSize
+	})
+	pkg := storage.Package{Registry: "registry.npmjs.org", Name: "x"}
+	from := storagetest.NewTarball(t, map[string]string{"package/big.js": "module.exports = 1\n", "package/same.js": "module.exports = 0\n"})
+	to := storagetest.NewTarball(t, map[string]string{"package/big.js": "module.exports = 2\n", "package/same.js": "module.exports = 0\n"})
+	summary, patch, err := Tarballs(storage.NewTarball(pkg, "x-1.0.0.tgz"), storage.NewTarball(pkg, "x-2.0.0.tgz"), from, to, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summary.Changed, []string{"big.js"}) {
+		t.Errorf("expected only big.js to be changed but got %v", summary.Changed)
+	}
+	expected := "diff --git a/big.js b/big.js\nBinary files a/big.js and b/big.js differ\n"
+	if patch != expected {
+		t.Errorf("expected %q but got %q", expected, patch)
+	}
+}
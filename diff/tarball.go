@@ -0,0 +1,265 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"enpeeem/storage"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LifecycleScripts are the scripts npm runs when installing a package.
+var LifecycleScripts = []string{"preinstall", "install", "postinstall", "preprepare", "prepare", "postprepare"}
+
+var dependencyTypes = []string{"dependencies", "devDependencies", "peerDependencies", "optionalDependencies", "bundleDependencies"}
+
+// Options change how tarballs are compared.
+type Options struct {
+	// IgnoreWhitespace ignores all whitespace when comparing lines.
+	IgnoreWhitespace bool
+	// Paths are globs of the files to compare, a glob matching a directory
+	// matches the files in it. All files are compared if it's empty.
+	Paths []string
+	// Context is the number of unchanged lines shown around changes.
+	Context int
+}
+
+// DependencyChange is a dependency added, removed or changed between versions,
+// From is empty for added and To for removed dependencies.
+type DependencyChange struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// ScriptChange is a lifecycle script added, removed or changed between versions.
+type ScriptChange struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Summary lists the files and the dependencies and lifecycle scripts in
+// package.json that changed between versions.
+type Summary struct {
+	Added            []string           `json:"added"`
+	Removed          []string           `json:"removed"`
+	Changed          []string           `json:"changed"`
+	Dependencies     []DependencyChange `json:"dependencies"`
+	LifecycleScripts []ScriptChange     `json:"lifecycleScripts"`
+}
+
+// ValidatePaths returns an error if a glob in paths is malformed.
+func ValidatePaths(paths []string) error {
+	for _, glob := range paths {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid path %s: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// Tarballs compares the contents of two gzipped tarballs and returns the summary
+// and the unified diff of the files, like npm diff.
+func Tarballs(from, to storage.Tarball, fromTgz, toTgz []byte, opts Options) (Summary, string, error) {
+	summary := Summary{Added: []string{}, Removed: []string{}, Changed: []string{}, Dependencies: []DependencyChange{}, LifecycleScripts: []ScriptChange{}}
+	// oversized files are compared by integrity and shown as binary
+	a, aOversized, err := from.ContentsFromTar(fromTgz)
+	if err != nil {
+		return summary, "", err
+	}
+	b, bOversized, err := to.ContentsFromTar(toTgz)
+	if err != nil {
+		return summary, "", err
+	}
+	if summary.Dependencies, summary.LifecycleScripts, err = comparePackageJson(a["/package.json"], b["/package.json"]); err != nil {
+		return summary, "", err
+	}
+
+	seen := map[string]struct{}{}
+	names := []string{}
+	for _, files := range []map[string][]byte{a, b} {
+		for name := range files {
+			if _, found := seen[name]; !found {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	for _, files := range []map[string]string{aOversized, bOversized} {
+		for name := range files {
+			if _, found := seen[name]; !found {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	equal := Equal
+	if opts.IgnoreWhitespace {
+		equal = EqualIgnoreWhitespace
+	}
+	patch := strings.Builder{}
+	for _, name := range names {
+		name := strings.TrimPrefix(name, "/")
+		if !matchPaths(opts.Paths, name) {
+			continue
+		}
+		fromData, inFrom := a["/"+name]
+		toData, inTo := b["/"+name]
+		fromIntegrity, fromOversized := aOversized["/"+name]
+		toIntegrity, toOversized := bOversized["/"+name]
+		// a file may be read in one tarball and oversized in the other
+		if toOversized && inFrom {
+			fromIntegrity = storage.Integrity(fromData)
+		}
+		if fromOversized && inTo {
+			toIntegrity = storage.Integrity(toData)
+		}
+		inFrom, inTo = inFrom || fromOversized, inTo || toOversized
+		oversized := fromOversized || toOversized
+		if oversized && fromIntegrity == toIntegrity || !oversized && bytes.Equal(fromData, toData) && inFrom == inTo {
+			continue
+		}
+		fromName, toName := "a/"+name, "b/"+name
+		header := fmt.Sprintf("diff --git a/%s b/%s\n", name, name)
+		switch {
+		case !inFrom:
+			summary.Added = append(summary.Added, name)
+			header += "new file mode 100644\n"
+			fromName = "/dev/null"
+		case !inTo:
+			summary.Removed = append(summary.Removed, name)
+			header += "deleted file mode 100644\n"
+			toName = "/dev/null"
+		}
+		if oversized || binary(fromData) || binary(toData) {
+			if inFrom && inTo {
+				summary.Changed = append(summary.Changed, name)
+			}
+			patch.WriteString(header)
+			fmt.Fprintf(&patch, "Binary files %s and %s differ\n", fromName, toName)
+			continue
+		}
+		hunks := Unified(SplitLines(string(fromData)), SplitLines(string(toData)), opts.Context, equal)
+		if inFrom && inTo {
+			if hunks == "" {
+				// only whitespace changed
+				continue
+			}
+			summary.Changed = append(summary.Changed, name)
+		}
+		patch.WriteString(header)
+		if hunks != "" {
+			fmt.Fprintf(&patch, "--- %s\n+++ %s\n%s", fromName, toName, hunks)
+		}
+	}
+	return summary, patch.String(), nil
+}
+
+func matchPaths(globs []string, name string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		glob = strings.Trim(glob, "/")
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+		// a glob matching a directory matches all files in it
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if matched, _ := path.Match(glob, dir); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// binary returns true if data has a NUL byte in the first 8000 bytes, like git.
+func binary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1
+}
+
+// comparePackageJson returns the changed dependencies and lifecycle scripts
+// between two package.json files, missing files have no dependencies.
+func comparePackageJson(from, to []byte) ([]DependencyChange, []ScriptChange, error) {
+	a, err := parsePackageJson(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := parsePackageJson(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	deps := []DependencyChange{}
+	for _, typ := range dependencyTypes {
+		fromDeps, toDeps := dependencies(a[typ]), dependencies(b[typ])
+		for _, name := range keys(fromDeps, toDeps) {
+			if fromDeps[name] != toDeps[name] {
+				deps = append(deps, DependencyChange{Type: typ, Name: name, From: fromDeps[name], To: toDeps[name]})
+			}
+		}
+	}
+	scripts := []ScriptChange{}
+	fromScripts, toScripts := stringMap(a["scripts"]), stringMap(b["scripts"])
+	for _, name := range LifecycleScripts {
+		if fromScripts[name] != toScripts[name] {
+			scripts = append(scripts, ScriptChange{Name: name, From: fromScripts[name], To: toScripts[name]})
+		}
+	}
+	return deps, scripts, nil
+}
+
+func parsePackageJson(data []byte) (map[string]any, error) {
+	pkgJson := map[string]any{}
+	if len(data) == 0 {
+		return pkgJson, nil
+	}
+	if err := json.Unmarshal(data, &pkgJson); err != nil {
+		return pkgJson, fmt.Errorf("error parsing package.json: %w", err)
+	}
+	return pkgJson, nil
+}
+
+// dependencies returns the version ranges by name of a dependency type, bundled
+// dependencies are a list of names and have the range bundled.
+func dependencies(v any) map[string]string {
+	if list, ok := v.([]any); ok {
+		deps := map[string]string{}
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				deps[name] = "bundled"
+			}
+		}
+		return deps
+	}
+	return stringMap(v)
+}
+
+func stringMap(v any) map[string]string {
+	m := map[string]string{}
+	obj, _ := v.(map[string]any)
+	for key, value := range obj {
+		m[key] = fmt.Sprint(value)
+	}
+	return m
+}
+
+// keys returns the keys of both maps sorted.
+func keys(a, b map[string]string) []string {
+	all := []string{}
+	for key := range a {
+		all = append(all, key)
+	}
+	for key := range b {
+		if _, found := a[key]; !found {
+			all = append(all, key)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
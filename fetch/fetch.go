@@ -0,0 +1,370 @@
+package fetch
+
+import (
+	"encoding/json"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/alitto/pond"
+)
+
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+var (
+	// finished jobs are kept this long for progress reporting
+	keepFinished = 24 * time.Hour
+)
+
+var ErrNotFound = errors.New("fetch job not found")
+
+// Downloader downloads and saves a tarball.
+type Downloader func(tarball storage.Tarball) error
+
+// Filter skips versions that shouldn't be downloaded.
+type Filter struct {
+	SkipPrerelease bool
+	// MaxAge skips versions published longer ago, versions without a publish
+	// time are never skipped.
+	MaxAge time.Duration
+}
+
+// Skip returns true if the version shouldn't be downloaded.
+func (f Filter) Skip(version string, published time.Time, now time.Time) bool {
+	if f.SkipPrerelease {
+		if v, err := semver.NewVersion(version); err == nil && v.Prerelease() != "" {
+			return true
+		}
+	}
+	return f.MaxAge > 0 && !published.IsZero() && now.Sub(published) > f.MaxAge
+}
+
+// Job downloads the missing tarballs of a package.
+type Job struct {
+	Package  string     `json:"package"`
+	State    string     `json:"state"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	Skipped  int        `json:"skipped"`
+	Pending  []string   `json:"pending,omitempty"`
+	Errors   []string   `json:"errors,omitempty"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	pkg storage.Package
+	// tarballs downloaded or being downloaded by this job
+	tried map[string]bool
+}
+
+// Queue downloads all versions of packages in the background with a bounded number
+// of workers. There's one job per package, versions added while a job is queued or
+// running are added to it. Unfinished jobs are saved to a state file and resumed
+// when the queue is started again.
+type Queue struct {
+	store     storage.Store
+	download  Downloader
+	filter    Filter
+	stateFile string
+	pool      *pond.WorkerPool
+	mux       sync.Mutex
+	jobs      map[string]*Job
+	queued    []*Job
+	wake      chan struct{}
+}
+
+// New returns a queue downloading tarballs with workers, loading unfinished jobs
+// from the state file.
+func New(store storage.Store, download Downloader, workers int, filter Filter, stateFile string) (*Queue, error) {
+	q := &Queue{
+		store:     store,
+		download:  download,
+		filter:    filter,
+		stateFile: stateFile,
+		pool:      pond.New(max(workers, 1), 0),
+		jobs:      map[string]*Job{},
+		wake:      make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	jobs := []*Job{}
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return q, fmt.Errorf("error parsing fetch queue %s: %w", stateFile, err)
+	}
+	for _, job := range jobs {
+		if job.pkg, err = storage.PackageMetadataFromURI(job.Package); err != nil {
+			return q, fmt.Errorf("error in fetch queue %s: %w", stateFile, err)
+		}
+		job.State, job.Started = StateQueued, nil
+		job.Total, job.Done, job.Failed, job.Errors = len(job.Pending), 0, 0, nil
+		q.jobs[job.Package] = job
+		q.queued = append(q.queued, job)
+	}
+	if len(jobs) > 0 {
+		slog.Info("resuming fetch queue", "jobs", len(jobs))
+	}
+	return q, nil
+}
+
+// Start runs queued jobs in the background.
+func (q *Queue) Start() {
+	go func() {
+		for {
+			job := q.next()
+			if job == nil {
+				<-q.wake
+				continue
+			}
+			// blocks until a worker is available
+			q.pool.Submit(func() {
+				q.run(job)
+			})
+		}
+	}()
+	q.notify()
+}
+
+// Add queues the missing tarballs of all versions in the package metadata that
+// aren't skipped by the filter.
+func (q *Queue) Add(pkg storage.Package, packageMetadata []byte) error {
+	pkmt := storage.PackageMetadata{}
+	if err := json.Unmarshal(packageMetadata, &pkmt); err != nil {
+		return err
+	}
+	existing, err := q.store.Tarballs(pkg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tarballs, skipped := []string{}, 0
+	for v := range pkmt.Versions {
+		version, _ := pkmt.Versions[v].(map[string]interface{})
+		dist, _ := version["dist"].(map[string]interface{})
+		tarballURL, _ := dist["tarball"].(string)
+		if tarballURL == "" {
+			continue
+		}
+		name := path.Base(tarballURL)
+		if slices.Contains(existing, storage.NewTarball(pkg, name)) {
+			continue
+		}
+		published, _ := pkmt.PublishTime(v)
+		if q.filter.Skip(v, published, now) {
+			skipped++
+			continue
+		}
+		tarballs = append(tarballs, name)
+	}
+	sort.Strings(tarballs)
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	job, found := q.jobs[pkg.String()]
+	if !found || job.State == StateDone || job.State == StateFailed {
+		if len(tarballs) == 0 {
+			return nil
+		}
+		job = &Job{Package: pkg.String(), State: StateQueued, Queued: now.UTC(), pkg: pkg}
+		q.jobs[job.Package] = job
+		q.queued = append(q.queued, job)
+		job.Skipped = skipped
+	}
+	added := 0
+	for _, name := range tarballs {
+		if !job.tried[name] && !slices.Contains(job.Pending, name) {
+			job.Pending = append(job.Pending, name)
+			added++
+		}
+	}
+	if added == 0 {
+		return nil
+	}
+	job.Total += added
+	slog.Debug("queued tarballs", "pkg", pkg.String(), "tarballs", added)
+	q.notify()
+	return q.save()
+}
+
+// Jobs returns all unfinished jobs and jobs finished recently, sorted by the time
+// they were queued.
+func (q *Queue) Jobs() []Job {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	jobs := []Job{}
+	for key, job := range q.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > keepFinished {
+			delete(q.jobs, key)
+			continue
+		}
+		jobs = append(jobs, job.copy())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Queued.Before(jobs[j].Queued)
+	})
+	return jobs
+}
+
+// Job returns the job of a package.
+func (q *Queue) Job(pkg storage.Package) (Job, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	job, found := q.jobs[pkg.String()]
+	if !found {
+		return Job{}, ErrNotFound
+	}
+	return job.copy(), nil
+}
+
+func (job *Job) copy() Job {
+	c := *job
+	c.Pending = slices.Clone(job.Pending)
+	c.Errors = slices.Clone(job.Errors)
+	return c
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next removes the first queued job.
+func (q *Queue) next() *Job {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if len(q.queued) == 0 {
+		return nil
+	}
+	job := q.queued[0]
+	q.queued = q.queued[1:]
+	return job
+}
+
+func (q *Queue) run(job *Job) {
+	q.mux.Lock()
+	started := time.Now().UTC()
+	job.State, job.Started = StateRunning, &started
+	job.tried = map[string]bool{}
+	q.mux.Unlock()
+
+	// tarballs of resumed jobs may already be downloaded
+	existing, err := q.store.Tarballs(job.pkg)
+	if err != nil {
+		slog.Error("failed to list tarballs", "pkg", job.Package, "cause", err)
+	}
+	indexed := 0
+	for {
+		q.fetchPending(job, existing)
+
+		// index without holding the lock so status requests are not blocked
+		q.mux.Lock()
+		done := job.Done
+		q.mux.Unlock()
+		if done > indexed {
+			indexed = done
+			if _, err := q.store.Index(job.pkg); err != nil {
+				q.mux.Lock()
+				job.Errors = append(job.Errors, fmt.Sprintf("error indexing: %s", err))
+				q.mux.Unlock()
+				slog.Error("failed to index package", "pkg", job.Package, "cause", err)
+			}
+		}
+
+		// versions added to the job while indexing are fetched before it finishes
+		q.mux.Lock()
+		if len(job.Pending) == 0 {
+			break
+		}
+		q.mux.Unlock()
+	}
+	defer q.mux.Unlock()
+	finished := time.Now().UTC()
+	job.State, job.Finished = StateDone, &finished
+	if job.Failed > 0 {
+		job.State = StateFailed
+	}
+	slog.Info("fetched all tarballs", "pkg", job.Package, "done", job.Done, "failed", job.Failed, "skipped", job.Skipped)
+	if err := q.save(); err != nil {
+		slog.Error("failed to save fetch queue", "cause", err)
+	}
+}
+
+// fetchPending downloads the pending tarballs of a job until none are left.
+func (q *Queue) fetchPending(job *Job, existing []storage.Tarball) {
+	for {
+		q.mux.Lock()
+		if len(job.Pending) == 0 {
+			q.mux.Unlock()
+			return
+		}
+		name := job.Pending[0]
+		job.Pending = job.Pending[1:]
+		job.tried[name] = true
+		q.mux.Unlock()
+
+		tarball := storage.NewTarball(job.pkg, name)
+		if slices.Contains(existing, tarball) {
+			q.mux.Lock()
+			job.Done++
+			q.mux.Unlock()
+			continue
+		}
+		// failed requests are already retried by the upstream client
+		slog.Info("downloading tarball", "url", tarball.RemoteURL())
+		err := q.download(tarball)
+		if err != nil {
+			slog.Error("failed to download tarball", "url", tarball.RemoteURL(), "cause", err)
+		}
+		q.mux.Lock()
+		if err != nil {
+			job.Failed++
+			job.Errors = append(job.Errors, fmt.Sprintf("%s: %s", name, err))
+		} else {
+			job.Done++
+		}
+		q.mux.Unlock()
+	}
+}
+
+// save writes unfinished jobs to the state file, q.mux must be held.
+func (q *Queue) save() error {
+	jobs := []*Job{}
+	for _, job := range q.jobs {
+		if job.State == StateQueued || job.State == StateRunning {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Queued.Before(jobs[j].Queued)
+	})
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(q.stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(q.stateFile, data, 0644)
+}
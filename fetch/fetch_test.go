@@ -0,0 +1,136 @@
+package fetch
+
+import (
+	"bytes"
+	"enpeeem/storage"
+	"enpeeem/storage/storagetest"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	type Test struct {
+		Filter    Filter
+		Version   string
+		Published time.Time
+		Expected  bool
+	}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []Test{
+		{Filter: Filter{}, Version: "1.0.0-rc.1", Published: now.AddDate(-5, 0, 0), Expected: false},
+		{Filter: Filter{SkipPrerelease: true}, Version: "1.0.0-rc.1", Expected: true},
+		{Filter: Filter{SkipPrerelease: true}, Version: "1.0.0", Expected: false},
+		{Filter: Filter{SkipPrerelease: true}, Version: "not-semver", Expected: false},
+		{Filter: Filter{MaxAge: 365 * 24 * time.Hour}, Version: "1.0.0", Published: now.AddDate(-2, 0, 0), Expected: true},
+		{Filter: Filter{MaxAge: 365 * 24 * time.Hour}, Version: "1.0.0", Published: now.AddDate(0, -1, 0), Expected: false},
+		{Filter: Filter{MaxAge: 365 * 24 * time.Hour}, Version: "1.0.0", Expected: false},
+	}
+	for _, test := range tests {
+		if actual := test.Filter.Skip(test.Version, test.Published, now); actual != test.Expected {
+			t.Errorf("%v %s: expected %v but got %v", test.Filter, test.Version, test.Expected, actual)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewFileStore(dir, dir)
+	pkg := storage.Package{Registry: "registry.npmjs.org", Name: "x"}
+	if err := store.PutTarball(storage.NewTarball(pkg, "x-1.0.0.tgz"), testTarball(t, "1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := sync.Mutex{}
+	attempts := map[string]int{}
+	done := make(chan struct{})
+	download := func(tarball storage.Tarball) error {
+		mux.Lock()
+		attempts[tarball.Name]++
+		mux.Unlock()
+		switch tarball.Version() {
+		case "1.1.0":
+			return errors.New("connection reset")
+		case "1.2.0":
+			return storage.ErrNotFound
+		case "1.3.0":
+			// block until the job is checked while running
+			<-done
+		}
+		return store.PutTarball(tarball, testTarball(t, tarball.Version()))
+	}
+	q, err := New(store, download, 2, Filter{SkipPrerelease: true}, filepath.Join(dir, ".enpeeem", "fetch.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Add(pkg, testMetadata("1.0.0", "1.1.0", "1.2.0", "1.3.0", "2.0.0-rc.1")); err != nil {
+		t.Fatal(err)
+	}
+	// not started yet, resumed from the state file by a new queue
+	q, err = New(store, download, 2, Filter{SkipPrerelease: true}, filepath.Join(dir, ".enpeeem", "fetch.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	waitFor(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return attempts["x-1.3.0.tgz"] == 1
+	})
+	// adding the same versions again while running doesn't duplicate them
+	if err := q.Add(pkg, testMetadata("1.0.0", "1.1.0", "1.2.0", "1.3.0")); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	waitFor(t, func() bool {
+		job, err := q.Job(pkg)
+		return err == nil && job.Finished != nil
+	})
+
+	job, _ := q.Job(pkg)
+	if job.State != StateFailed || job.Total != 3 || job.Done != 1 || job.Failed != 2 {
+		t.Errorf("expected failed job with 1 of 3 done but got %+v", job)
+	}
+	// retries are left to the upstream client
+	if attempts["x-1.1.0.tgz"] != 1 {
+		t.Errorf("expected 1 attempt but got %d", attempts["x-1.1.0.tgz"])
+	}
+	pkmt, err := store.GetPackageMetadata(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkmt.Versions) != 2 {
+		t.Errorf("expected 2 indexed versions but got %d", len(pkmt.Versions))
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for condition")
+}
+
+func testTarball(t *testing.T, version string) []byte {
+	return storagetest.NewTarball(t, map[string]string{"package/package.json": fmt.Sprintf(`{"name":"x","version":"%s"}`, version)})
+}
+
+func testMetadata(versions ...string) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(`{"name":"x","versions":{`)
+	for i, v := range versions {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `"%s":{"dist":{"tarball":"https://registry.npmjs.org/x/-/x-%s.tgz"}}`, v, v)
+	}
+	buf.WriteString(`}}`)
+	return buf.Bytes()
+}
@@ -0,0 +1,266 @@
+package handle
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/reindex"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAdminLimit = 50
+	maxAdminLimit     = 1000
+)
+
+// AdminPackage is a package in the admin package list.
+type AdminPackage struct {
+	Registry string `json:"registry"`
+	Name     string `json:"name"`
+	Latest   string `json:"latest,omitempty"`
+	Versions int    `json:"versions"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// AdminPackageList is a page of the admin package list.
+type AdminPackageList struct {
+	Total    int            `json:"total"`
+	Offset   int            `json:"offset"`
+	Limit    int            `json:"limit"`
+	Packages []AdminPackage `json:"packages"`
+}
+
+// AdminPackageVersions is a package with all stashed versions.
+type AdminPackageVersions struct {
+	Registry string                   `json:"registry"`
+	Name     string                   `json:"name"`
+	DistTags map[string]string        `json:"distTags"`
+	Versions []storage.IndexedVersion `json:"versions"`
+}
+
+// Admin wraps handlers of the admin API, requests must have the admin token as
+// bearer token. The admin API is disabled if no token is set.
+func Admin(handler func(w http.ResponseWriter, r *http.Request) (int, error)) func(w http.ResponseWriter, r *http.Request) (int, error) {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		cfg := config.FromContext(r)
+		if cfg.AdminToken == "" {
+			return http.StatusNotFound, fmt.Errorf("admin api is not enabled")
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="enpeeem"`)
+			return http.StatusUnauthorized, fmt.Errorf("invalid admin token")
+		}
+		return handler(w, r)
+	}
+}
+
+// AdminRegistries responds with the number of packages and versions and the size
+// of the stash per registry.
+func AdminRegistries(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	var stats storage.Stats
+	var err error
+	if cfg.SQLIndex != nil {
+		stats, err = cfg.SQLIndex.Stats()
+	} else {
+		stats, err = storage.GetStats(cfg.Store)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeJSON(w, stats)
+}
+
+// AdminPackages responds with a page of packages sorted by registry and name.
+// Packages can be filtered by registry, scope and a text in the name with the
+// query parameters registry, scope and q.
+func AdminPackages(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	query := r.URL.Query()
+	offset, limit := 0, defaultAdminLimit
+	if o := query.Get("offset"); o != "" {
+		var err error
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid offset %s", o)
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			return http.StatusBadRequest, fmt.Errorf("invalid limit %s", l)
+		}
+		limit = min(limit, maxAdminLimit)
+	}
+	pkgs, err := cfg.Store.Packages()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	registry, scope, text := query.Get("registry"), query.Get("scope"), strings.ToLower(query.Get("q"))
+	matches := []storage.Package{}
+	for _, pkg := range pkgs {
+		if (registry != "" && pkg.Registry != registry) || (scope != "" && pkg.Scope != scope) {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(pkg.FullName()), text) {
+			continue
+		}
+		matches = append(matches, pkg)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].String() < matches[j].String()
+	})
+
+	list := AdminPackageList{Total: len(matches), Offset: offset, Limit: limit, Packages: []AdminPackage{}}
+	for _, pkg := range matches[min(offset, len(matches)):min(offset+limit, len(matches))] {
+		ap := AdminPackage{Registry: pkg.Registry, Name: pkg.FullName()}
+		if pkmt, err := cfg.Store.GetPackageMetadata(pkg); err == nil {
+			ap.Latest = pkmt.DistTags["latest"]
+		}
+		tarballs, err := cfg.Store.Tarballs(pkg)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		for _, tarball := range tarballs {
+			if info, err := cfg.Store.StatTarball(tarball); err == nil {
+				ap.Versions++
+				ap.Bytes += info.Size
+			}
+		}
+		list.Packages = append(list.Packages, ap)
+	}
+	return writeJSON(w, list)
+}
+
+// AdminVersions responds with the stashed versions of a package with their size,
+// integrity and publish time.
+func AdminVersions(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	tarballs, err := cfg.Store.Tarballs(pkg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	pkmt, err := cfg.Store.GetPackageMetadata(pkg)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return http.StatusInternalServerError, err
+	}
+	if len(tarballs) == 0 && err != nil {
+		return http.StatusNotFound, fmt.Errorf("package %s not found", pkg.String())
+	}
+	versions := []storage.IndexedVersion{}
+	for _, tarball := range tarballs {
+		info, err := cfg.Store.StatTarball(tarball)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		version := tarball.Version()
+		versions = append(versions, storage.IndexedVersion{
+			Version:   version,
+			Tarball:   tarball.Name,
+			Integrity: pkmt.Integrity(version),
+			Size:      info.Size,
+			Published: pkmt.Time[version],
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Published < versions[j].Published
+	})
+	distTags := pkmt.DistTags
+	if distTags == nil {
+		distTags = map[string]string{}
+	}
+	return writeJSON(w, AdminPackageVersions{Registry: pkg.Registry, Name: pkg.FullName(), DistTags: distTags, Versions: versions})
+}
+
+// AdminDeletePackage removes a package with all its versions.
+func AdminDeletePackage(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	tarballs, err := cfg.Store.Tarballs(pkg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if _, err := cfg.Store.GetPackageMetadataRaw(pkg); len(tarballs) == 0 && errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, fmt.Errorf("package %s not found", pkg.String())
+	}
+	slog.Info("deleting package", "pkg", pkg.String())
+	if err := cfg.Store.DeletePackage(pkg); err != nil {
+		return deleteStatus(err), err
+	}
+	cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventUnpublish, Registry: pkg.Registry, Name: pkg.FullName()})
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// AdminDeleteVersion removes the tarball of a version and indexes the package.
+func AdminDeleteVersion(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	version := r.PathValue("version")
+	tarball := storage.NewTarball(pkg, versionTarballName(pkg, version))
+	// the tarball name in the metadata is used if it's not the name npm uses
+	if pkmt, err := cfg.Store.GetPackageMetadata(pkg); err == nil {
+		meta, _ := pkmt.Versions[version].(map[string]interface{})
+		dist, _ := meta["dist"].(map[string]interface{})
+		if tarballURL, _ := dist["tarball"].(string); tarballURL != "" {
+			tarball = storage.NewTarball(pkg, path.Base(tarballURL))
+		}
+	}
+	slog.Info("deleting tarball", "tarball", tarball.String())
+	if err := cfg.Store.DeleteTarball(tarball); errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, fmt.Errorf("version %s of %s not found", version, pkg.String())
+	} else if err != nil {
+		return deleteStatus(err), err
+	}
+	if _, err := cfg.Store.Index(pkg); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventUnpublish, Registry: pkg.Registry, Name: pkg.FullName(), Version: version, Change: map[string]string{"version": version}})
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// AdminStartIndex starts indexing all packages in the background, like the flag
+// index-all.
+func AdminStartIndex(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	job, err := cfg.Reindex.Start()
+	if errors.Is(err, reindex.ErrRunning) {
+		return http.StatusConflict, err
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted, json.NewEncoder(w).Encode(job)
+}
+
+// AdminIndex responds with the progress of indexing all packages.
+func AdminIndex(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	job, err := cfg.Reindex.Job()
+	if errors.Is(err, reindex.ErrNotStarted) {
+		return http.StatusNotFound, err
+	}
+	return writeJSON(w, job)
+}
@@ -0,0 +1,38 @@
+package handle
+
+import (
+	"enpeeem/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	type Test struct {
+		Name          string
+		Token         string
+		Authorization string
+		Status        int
+	}
+	tests := []Test{
+		{"disabled", "", "Bearer ", http.StatusNotFound},
+		{"missing", "secret", "", http.StatusUnauthorized},
+		{"wrong", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"basic", "secret", "Basic secret", http.StatusUnauthorized},
+		{"valid", "secret", "Bearer secret", http.StatusOK},
+	}
+	handler := Admin(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return http.StatusOK, nil
+	})
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/registries", nil)
+		if test.Authorization != "" {
+			r.Header.Set("Authorization", test.Authorization)
+		}
+		r = config.Config{AdminToken: test.Token}.ToContext(r)
+		status, _ := handler(httptest.NewRecorder(), r)
+		if status != test.Status {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Status, status)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handle
+
+import (
+	"enpeeem/changes"
+	"enpeeem/config"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultChangesTimeout = time.Minute
+	maxChangesTimeout     = 5 * time.Minute
+)
+
+// Changes responds with changes after the since sequence number, similar to the
+// CouchDB changes feed. Use since=now to only get future changes. With
+// feed=longpoll the request waits for a change if there are none yet.
+func Changes(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Changes == nil {
+		return http.StatusNotFound, fmt.Errorf("changes feed is not enabled")
+	}
+	query := r.URL.Query()
+	since := int64(0)
+	switch s := query.Get("since"); s {
+	case "", "0":
+	case "now":
+		since = cfg.Changes.Seq()
+	default:
+		var err error
+		if since, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid since %s", s)
+		}
+	}
+	limit := 0
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid limit %s", l)
+		}
+	}
+	timeout := defaultChangesTimeout
+	if t := query.Get("timeout"); t != "" {
+		ms, err := strconv.Atoi(t)
+		if err != nil || ms < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid timeout %s", t)
+		}
+		timeout = min(time.Duration(ms)*time.Millisecond, maxChangesTimeout)
+	}
+
+	var results []changes.Change
+	var err error
+	switch query.Get("feed") {
+	case "", "normal":
+		results, err = cfg.Changes.Since(since, limit)
+	case "longpoll":
+		results, err = cfg.Changes.Wait(r.Context(), since, limit, timeout)
+	default:
+		return http.StatusBadRequest, fmt.Errorf("unsupported feed %s", query.Get("feed"))
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	lastSeq := since
+	if len(results) > 0 {
+		lastSeq = results[len(results)-1].Seq
+	}
+	return writeJSON(w, map[string]any{"results": results, "last_seq": lastSeq})
+}
@@ -0,0 +1,73 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/cooldown"
+	"enpeeem/storage"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Cooldown responds with all versions currently in cooldown.
+func Cooldown(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	versions := []cooldown.Version{}
+	if cfg.Cooldown != nil {
+		versions = cfg.Cooldown.Versions()
+	}
+	w.Header().Add("Content-Type", "application/json")
+	return http.StatusOK, json.NewEncoder(w).Encode(versions)
+}
+
+// ReleaseCooldown allows a version in cooldown to be fetched before the cooldown ends.
+func ReleaseCooldown(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Cooldown == nil {
+		return http.StatusNotFound, fmt.Errorf("cooldown is not enabled")
+	}
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := cfg.Cooldown.Release(pkg, r.PathValue("version")); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// checkCooldown returns cooldown.ErrCooldown if the tarball version is in cooldown. Publish
+// times are fetched from the remote registry if not known, the publish times used are
+// returned.
+func checkCooldown(cfg config.Config, tarball storage.Tarball) (map[string]string, error) {
+	if cfg.Cooldown == nil {
+		return nil, nil
+	}
+	pkg := tarball.Package()
+	times, err := storage.GetTimes(cfg.Store, pkg)
+	if err != nil {
+		return times, err
+	}
+	if _, found := times[tarball.Version()]; !found {
+		data, err := storage.FetchPackageMetadataRemotely(pkg)
+		if err != nil {
+			return times, err
+		}
+		if times, err = remoteTimes(data); err != nil {
+			return times, err
+		}
+	}
+	pkmt := storage.PackageMetadata{Time: times}
+	published, found := pkmt.PublishTime(tarball.Version())
+	allowed, err := cfg.Cooldown.Allowed(pkg, tarball.Version(), published, found)
+	if err != nil {
+		return times, err
+	}
+	if !allowed {
+		return times, fmt.Errorf("%w: %s was published %s", cooldown.ErrCooldown, tarball.String(), published.Format(time.RFC3339))
+	}
+	return times, nil
+}
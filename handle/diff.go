@@ -0,0 +1,105 @@
+package handle
+
+import (
+	"enpeeem/config"
+	"enpeeem/diff"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultDiffContext = 3
+
+// PackageDiff is the difference between two versions of a package.
+type PackageDiff struct {
+	Package string       `json:"package"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Summary diff.Summary `json:"summary"`
+	Patch   string       `json:"patch"`
+}
+
+// Diff responds with the changes between two stashed versions of a package, in a
+// path like left-pad/1.0.0...1.3.0. Versions can be dist-tags or semver ranges.
+// The query parameters ignoreWhitespace, path and context set the diff options,
+// with format=patch only the unified diff is returned as text.
+func Diff(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	name, fromSpec, toSpec, found := parseDiffPath(r.PathValue("path"))
+	if !found {
+		return http.StatusNotFound, fmt.Errorf("expected package/from...to in %s", r.URL.Path)
+	}
+	query := r.URL.Query()
+	opts := diff.Options{Paths: query["path"], Context: defaultDiffContext}
+	if err := diff.ValidatePaths(opts.Paths); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if ws := query.Get("ignoreWhitespace"); ws != "" {
+		var err error
+		if opts.IgnoreWhitespace, err = strconv.ParseBool(ws); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid ignoreWhitespace %s", ws)
+		}
+	}
+	if c := query.Get("context"); c != "" {
+		var err error
+		if opts.Context, err = strconv.Atoi(c); err != nil || opts.Context < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid context %s", c)
+		}
+	}
+
+	s, p := splitPkg(name)
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	pkmt, err := cfg.Store.GetPackageMetadata(pkg)
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, fmt.Errorf("package %s not found", pkg.String())
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	from, err := pkmt.ResolveVersion(fromSpec)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	to, err := pkmt.ResolveVersion(toSpec)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	fromTarball, fromData, status, err := stashedTarball(cfg.Store, pkg, pkmt, from)
+	if err != nil {
+		return status, err
+	}
+	toTarball, toData, status, err := stashedTarball(cfg.Store, pkg, pkmt, to)
+	if err != nil {
+		return status, err
+	}
+	summary, patch, err := diff.Tarballs(fromTarball, toTarball, fromData, toData, opts)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if query.Get("format") == "patch" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.Write([]byte(patch))
+		return http.StatusOK, nil
+	}
+	return writeJSON(w, PackageDiff{Package: pkg.FullName(), From: from, To: to, Summary: summary, Patch: patch})
+}
+
+// parseDiffPath splits a path like @scope/name/from...to into the package name
+// and both versions.
+func parseDiffPath(p string) (name, from, to string, found bool) {
+	i := strings.LastIndex(p, "/")
+	if i < 1 {
+		return "", "", "", false
+	}
+	from, to, found = strings.Cut(p[i+1:], "...")
+	if !found || from == "" || to == "" {
+		return "", "", "", false
+	}
+	return p[:i], from, to, true
+}
@@ -0,0 +1,26 @@
+package handle
+
+import "testing"
+
+func TestParseDiffPath(t *testing.T) {
+	type Test struct {
+		Path  string
+		Name  string
+		From  string
+		To    string
+		Found bool
+	}
+	tests := []Test{
+		{"left-pad/1.0.0...1.3.0", "left-pad", "1.0.0", "1.3.0", true},
+		{"@types/react/^17...latest", "@types/react", "^17", "latest", true},
+		{"left-pad/1.0.0..1.3.0", "", "", "", false},
+		{"left-pad/...1.3.0", "", "", "", false},
+		{"1.0.0...1.3.0", "", "", "", false},
+	}
+	for _, test := range tests {
+		name, from, to, found := parseDiffPath(test.Path)
+		if name != test.Name || from != test.From || to != test.To || found != test.Found {
+			t.Errorf("%s: expected %v but got %v", test.Path, test, Test{test.Path, name, from, to, found})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DistTags responds with all dist-tags for a package, or the version for a
+// single tag if a tag is given.
+func DistTags(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	pkmt, status, err := localMetadata(cfg.Store, pkg)
+	if err != nil {
+		return status, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	tag := r.PathValue("tag")
+	if tag == "" {
+		return http.StatusOK, json.NewEncoder(w).Encode(pkmt.DistTags)
+	}
+	version, found := pkmt.DistTags[tag]
+	if !found {
+		return http.StatusNotFound, fmt.Errorf("dist-tag %s not found for %s", tag, pkg.String())
+	}
+	return http.StatusOK, json.NewEncoder(w).Encode(version)
+}
+
+// PutDistTag sets a dist-tag to the version given as a JSON string in the request body.
+func PutDistTag(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := storage.ValidateDistTag(r.PathValue("tag")); err != nil {
+		return http.StatusBadRequest, err
+	}
+	version := ""
+	if err := json.NewDecoder(r.Body).Decode(&version); err != nil {
+		return http.StatusBadRequest, err
+	}
+	pkmt, status, err := localMetadata(cfg.Store, pkg)
+	if err != nil {
+		return status, err
+	}
+	if _, found := pkmt.Versions[version]; !found {
+		return http.StatusBadRequest, fmt.Errorf("version %s not found for %s", version, pkg.String())
+	}
+	status, err = updateDistTags(w, cfg, pkg, func(tags map[string]string) {
+		tags[r.PathValue("tag")] = version
+	})
+	if err == nil {
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventDistTag, Registry: pkg.Registry, Name: pkg.FullName(), Version: version, Change: map[string]string{"dist-tag": r.PathValue("tag"), "version": version}})
+	}
+	return status, err
+}
+
+// DeleteDistTag removes a dist-tag. The latest tag can't be removed, like npm
+// every package has one.
+func DeleteDistTag(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tag := r.PathValue("tag")
+	if tag == "latest" {
+		return http.StatusBadRequest, fmt.Errorf("dist-tag latest can't be removed from %s", pkg.String())
+	}
+	pkmt, status, err := localMetadata(cfg.Store, pkg)
+	if err != nil {
+		return status, err
+	}
+	if _, found := pkmt.DistTags[tag]; !found {
+		return http.StatusNotFound, fmt.Errorf("dist-tag %s not found for %s", tag, pkg.String())
+	}
+	status, err = updateDistTags(w, cfg, pkg, func(tags map[string]string) {
+		delete(tags, tag)
+	})
+	if err == nil {
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventDistTagRm, Registry: pkg.Registry, Name: pkg.FullName(), Change: map[string]string{"dist-tag": tag}})
+	}
+	return status, err
+}
+
+func updateDistTags(w http.ResponseWriter, cfg config.Config, pkg storage.Package, update func(map[string]string)) (int, error) {
+	if err := storage.UpdateDistTags(cfg.Store, pkg, update); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	pkmt, err := cfg.Store.Index(pkg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Add("Content-Type", "application/json")
+	return http.StatusOK, json.NewEncoder(w).Encode(pkmt.DistTags)
+}
+
+// localMetadata loads package metadata from local storage, indexing the package
+// if needed. The returned status code is to be used if an error is returned.
+func localMetadata(store storage.Store, pkg storage.Package) (storage.PackageMetadata, int, error) {
+	pkmt := storage.PackageMetadata{}
+	data, err := localPackageMetadata(store, pkg)
+	if errors.Is(err, storage.ErrNotFound) {
+		return pkmt, http.StatusNotFound, err
+	}
+	if err != nil {
+		return pkmt, http.StatusInternalServerError, err
+	}
+	if err := json.Unmarshal(data, &pkmt); err != nil {
+		return pkmt, http.StatusInternalServerError, err
+	}
+	return pkmt, http.StatusOK, nil
+}
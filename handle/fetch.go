@@ -1,52 +1,106 @@
 package handle
 
 import (
-	"encoding/json"
 	"enpeeem/config"
+	"enpeeem/fetch"
 	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
 	"log/slog"
-	"path"
-	"slices"
+	"net/http"
 )
 
-func FetchAll(cfg config.Config, pkg storage.Package, packageMetadata []byte) error {
-	type PackageMetadata struct {
-		Versions map[string]struct {
-			Dist struct {
-				Tarball string
-			}
-		}
+// FetchJobs responds with the progress of all fetch-all jobs.
+func FetchJobs(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Fetch == nil {
+		return http.StatusNotFound, fmt.Errorf("fetch-all is not enabled")
 	}
-	jsn := PackageMetadata{}
-	err := json.Unmarshal(packageMetadata, &jsn)
-	if err != nil {
-		return err
+	return writeJSON(w, cfg.Fetch.Jobs())
+}
+
+// FetchJob responds with the progress of the fetch-all job of a package.
+func FetchJob(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Fetch == nil {
+		return http.StatusNotFound, fmt.Errorf("fetch-all is not enabled")
 	}
-	existingTarballs, err := cfg.Store.Tarballs(pkg)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
 	if err != nil {
-		return err
+		return http.StatusBadRequest, err
 	}
-	for k := range jsn.Versions {
-		file := path.Base(jsn.Versions[k].Dist.Tarball)
-		tarball := storage.NewTarball(pkg, file)
-
-		// skip if tarball exist
-		if slices.Contains(existingTarballs, tarball) {
-			continue
-		}
+	job, err := cfg.Fetch.Job(pkg)
+	if errors.Is(err, fetch.ErrNotFound) {
+		return http.StatusNotFound, err
+	}
+	return writeJSON(w, job)
+}
 
-		slog.Info("downloading tarball", "url", tarball.RemoteURL())
-		if err := fetchAndSave(cfg, tarball); err != nil {
-			slog.Error("failed to download tarball", "cause", err, "url", tarball.RemoteURL())
-		}
+// FetchTarball downloads a tarball for the fetch-all queue.
+func FetchTarball(cfg config.Config, tarball storage.Tarball) error {
+	if _, err := fetchAndSave(cfg, tarball); err != nil {
+		return err
 	}
+	pkg := tarball.Package()
+	cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventStash, Registry: pkg.Registry, Name: pkg.FullName(), Version: tarball.Version(), Change: map[string]string{"tarball": tarball.Name}})
 	return nil
 }
 
-func fetchAndSave(cfg config.Config, tarball storage.Tarball) error {
+func fetchAndSave(cfg config.Config, tarball storage.Tarball) ([]byte, error) {
+	if internal, err := internal(cfg, tarball.Package()); err != nil {
+		return []byte{}, err
+	} else if internal {
+		return []byte{}, storage.ErrNotFound
+	}
+	if err := checkPackage(cfg, tarball.Package()); err != nil {
+		return []byte{}, err
+	}
+	times, err := checkCooldown(cfg, tarball)
+	if err != nil {
+		return []byte{}, err
+	}
 	data, err := tarball.FetchRemotely()
 	if err != nil {
-		return err
+		return data, err
+	}
+	if err := storage.CheckRecordedIntegrity(cfg.Store, tarball, data); err != nil {
+		slog.Warn("refusing tarball not matching recorded integrity", "tarball", tarball.String(), "cause", err)
+		return []byte{}, err
+	}
+	if err := checkPolicy(cfg, tarball, data); err != nil {
+		return []byte{}, err
+	}
+	if err := cfg.Store.PutTarball(tarball, data); err != nil {
+		return data, err
+	}
+	if err := stashTimes(cfg, tarball, times); err != nil {
+		slog.Error("failed to save publish times", "pkg", tarball.Package().String(), "cause", err)
+	}
+	return data, nil
+}
+
+// stashTimes saves the publish times of a package once a tarball is stashed,
+// times are only kept for stashed packages. The remote package metadata is
+// fetched if the given times don't include the version of the tarball.
+func stashTimes(cfg config.Config, tarball storage.Tarball, times map[string]string) error {
+	pkg := tarball.Package()
+	if _, found := times[tarball.Version()]; !found {
+		saved, err := storage.GetTimes(cfg.Store, pkg)
+		if err != nil {
+			return err
+		}
+		if _, found := saved[tarball.Version()]; found {
+			return nil
+		}
+		data, err := storage.FetchPackageMetadataRemotely(pkg)
+		if err != nil {
+			return err
+		}
+		if times, err = remoteTimes(data); err != nil {
+			return err
+		}
 	}
-	return cfg.Store.PutTarball(tarball, data)
+	return storage.PutTimes(cfg.Store, pkg, times)
 }
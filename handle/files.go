@@ -0,0 +1,269 @@
+package handle
+
+import (
+	"bufio"
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// textTypes are content types of files common in packages that are missing from
+// the mime types of many systems.
+var textTypes = map[string]string{
+	".cjs":      "application/javascript; charset=utf-8",
+	".cts":      "text/plain; charset=utf-8",
+	".js":       "application/javascript; charset=utf-8",
+	".json":     "application/json; charset=utf-8",
+	".jsx":      "text/plain; charset=utf-8",
+	".map":      "application/json; charset=utf-8",
+	".markdown": "text/markdown; charset=utf-8",
+	".md":       "text/markdown; charset=utf-8",
+	".mjs":      "application/javascript; charset=utf-8",
+	".mts":      "text/plain; charset=utf-8",
+	".ts":       "text/plain; charset=utf-8",
+	".tsx":      "text/plain; charset=utf-8",
+	".yaml":     "text/yaml; charset=utf-8",
+	".yml":      "text/yaml; charset=utf-8",
+}
+
+// FileEntry is a file or directory in a directory listing of a tarball.
+type FileEntry struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// FileListing is a directory listing of a tarball.
+type FileListing struct {
+	Package string      `json:"package"`
+	Version string      `json:"version"`
+	Path    string      `json:"path"`
+	Files   []FileEntry `json:"files"`
+}
+
+// Files responds with a file from a stashed tarball or, for paths ending with a
+// slash, a listing of the directory. The version in the path can be a version,
+// a dist-tag or a semver range, versions other than exact versions redirect to
+// the resolved version like unpkg. Paths without a file redirect to the main
+// file of the package.
+func Files(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	name, spec, file, found := parseFilesPath(r.PathValue("path"))
+	if name == "" {
+		return http.StatusNotFound, fmt.Errorf("invalid package in %s", r.URL.Path)
+	}
+	s, p := splitPkg(name)
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	pkmt, err := cfg.Store.GetPackageMetadata(pkg)
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, fmt.Errorf("package %s not found", pkg.String())
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	version, err := pkmt.ResolveVersion(spec)
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tarball, data, status, err := stashedTarball(cfg.Store, pkg, pkmt, version)
+	if err != nil {
+		return status, err
+	}
+
+	if !found {
+		file, err = mainFile(tarball, data)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	if version != spec || !found {
+		return redirect(w, r, filesURL(pkg, version, file))
+	}
+	if strings.HasSuffix(file, "/") {
+		return listFiles(w, tarball, data, pkg, version, file)
+	}
+
+	started := false
+	err = tarball.FileFromTar(data, file, func(f storage.TarFile, tr io.Reader) error {
+		br := bufio.NewReader(tr)
+		w.Header().Set("Content-Type", contentType(f.Path, br))
+		w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+		w.Header().Set("Last-Modified", f.ModTime.UTC().Format(http.TimeFormat))
+		// files are untrusted, browsers must not run scripts in them on this origin
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		started = true
+		_, err := io.Copy(w, br)
+		return err
+	})
+	if started {
+		if err != nil {
+			slog.Error("error streaming file", "cause", err, "tarball", tarball.String(), "file", file)
+		}
+		return http.StatusOK, nil
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// directories without the trailing slash redirect like unpkg
+		if files, _ := tarball.FilesFromTar(data); len(directory(files, file+"/")) > 0 {
+			return redirect(w, r, filesURL(pkg, version, file+"/"))
+		}
+		return http.StatusNotFound, err
+	}
+	return http.StatusInternalServerError, err
+}
+
+// stashedTarball returns the tarball of a version and its content with the status
+// to respond with if it's not stashed.
+func stashedTarball(store storage.Store, pkg storage.Package, pkmt storage.PackageMetadata, version string) (storage.Tarball, []byte, int, error) {
+	tarballName := pkmt.TarballName(version)
+	if tarballName == "" {
+		tarballName = versionTarballName(pkg, version)
+	}
+	tarball := storage.NewTarball(pkg, tarballName)
+	data, err := store.GetTarball(tarball)
+	if errors.Is(err, storage.ErrNotFound) {
+		return tarball, data, http.StatusNotFound, fmt.Errorf("version %s of %s is not stashed", version, pkg.String())
+	}
+	if err != nil {
+		return tarball, data, http.StatusInternalServerError, err
+	}
+	return tarball, data, http.StatusOK, nil
+}
+
+func listFiles(w http.ResponseWriter, tarball storage.Tarball, data []byte, pkg storage.Package, version, dir string) (int, error) {
+	files, err := tarball.FilesFromTar(data)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	entries := directory(files, strings.TrimSuffix(path.Clean(dir), "/")+"/")
+	if len(entries) == 0 && dir != "/" {
+		return http.StatusNotFound, fmt.Errorf("directory %s not found in %s", dir, tarball.String())
+	}
+	return writeJSON(w, FileListing{Package: pkg.FullName(), Version: version, Path: dir, Files: entries})
+}
+
+// directory returns the files and subdirectories directly in dir, which ends with
+// a slash, sorted by path.
+func directory(files []storage.TarFile, dir string) []FileEntry {
+	entries := []FileEntry{}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		rest, found := strings.CutPrefix(f.Path, dir)
+		if !found {
+			continue
+		}
+		if sub, _, isDir := strings.Cut(rest, "/"); isDir {
+			if !dirs[sub] {
+				dirs[sub] = true
+				entries = append(entries, FileEntry{Path: dir + sub + "/", Type: "directory"})
+			}
+			continue
+		}
+		entries = append(entries, FileEntry{Path: f.Path, Type: "file", ContentType: contentType(f.Path, nil), Size: f.Size})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// parseFilesPath splits a path like @scope/name@version/file into the package
+// name, the version and the file starting with a slash. found is false if there
+// is no slash after the version.
+func parseFilesPath(p string) (name, spec, file string, found bool) {
+	scope := ""
+	if strings.HasPrefix(p, "@") {
+		var ok bool
+		if scope, p, ok = strings.Cut(p, "/"); !ok {
+			return "", "", "", false
+		}
+		scope += "/"
+	}
+	nameSpec, file, found := strings.Cut(p, "/")
+	name, spec, _ = strings.Cut(nameSpec, "@")
+	if name == "" {
+		return "", "", "", false
+	}
+	if found {
+		file = "/" + file
+	}
+	return scope + name, spec, file, found
+}
+
+// mainFile returns the main file of the package in the tarball, /index.js if
+// package.json doesn't set it. Like Node it tries main, main with .js added and
+// index.js in the main directory, the first one in the tarball is returned.
+func mainFile(tarball storage.Tarball, data []byte) (string, error) {
+	pkgJson, err := tarball.PackageJsonFromTar(data)
+	if err != nil {
+		return "", err
+	}
+	pj := struct {
+		Main string `json:"main"`
+	}{}
+	if err := json.Unmarshal(pkgJson, &pj); err != nil {
+		return "", fmt.Errorf("error parsing package.json of %s: %w", tarball.String(), err)
+	}
+	if pj.Main == "" {
+		return "/index.js", nil
+	}
+	files, err := tarball.FilesFromTar(data)
+	if err != nil {
+		return "", err
+	}
+	main := path.Clean("/" + pj.Main)
+	for _, candidate := range []string{main, main + ".js", path.Join(main, "index.js")} {
+		if slices.ContainsFunc(files, func(file storage.TarFile) bool { return file.Path == candidate }) {
+			return candidate, nil
+		}
+	}
+	return main, nil
+}
+
+// contentType guesses the content type from the extension of name and from the
+// content in r if the extension is unknown and r is not nil.
+func contentType(name string, r *bufio.Reader) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, found := textTypes[ext]; found {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	if r == nil {
+		// files without extension in packages are mostly text, like LICENSE
+		if ext == "" {
+			return "text/plain; charset=utf-8"
+		}
+		return "application/octet-stream"
+	}
+	head, _ := r.Peek(512)
+	return http.DetectContentType(head)
+}
+
+func filesURL(pkg storage.Package, version, file string) string {
+	return fmt.Sprintf("/-/files/%s@%s%s", pkg.FullName(), version, file)
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, url string) (int, error) {
+	http.Redirect(w, r, url, http.StatusFound)
+	return http.StatusFound, nil
+}
@@ -0,0 +1,89 @@
+package handle
+
+import (
+	"enpeeem/storage"
+	"enpeeem/storage/storagetest"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestParseFilesPath(t *testing.T) {
+	type Test struct {
+		Path  string
+		Name  string
+		Spec  string
+		File  string
+		Found bool
+	}
+	tests := []Test{
+		{"left-pad@1.3.0/index.js", "left-pad", "1.3.0", "/index.js", true},
+		{"left-pad@^1/", "left-pad", "^1", "/", true},
+		{"left-pad", "left-pad", "", "", false},
+		{"@types/react@latest/index.d.ts", "@types/react", "latest", "/index.d.ts", true},
+		{"@types/react/", "@types/react", "", "/", true},
+		{"@types", "", "", "", false},
+		{"@types/@1.0.0/x", "", "", "", false},
+	}
+	for _, test := range tests {
+		name, spec, file, found := parseFilesPath(test.Path)
+		if name != test.Name || spec != test.Spec || file != test.File || found != test.Found {
+			t.Errorf("%s: expected %v but got %v", test.Path, test, Test{test.Path, name, spec, file, found})
+		}
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	files := []storage.TarFile{{Path: "/LICENSE"}, {Path: "/lib/a.js"}, {Path: "/lib/b/c.js"}, {Path: "/lib/b/d.js"}, {Path: "/package.json"}}
+	type Test struct {
+		Dir      string
+		Expected []string
+	}
+	tests := []Test{
+		{"/", []string{"/LICENSE", "/lib/", "/package.json"}},
+		{"/lib/", []string{"/lib/a.js", "/lib/b/"}},
+		{"/lib/b/", []string{"/lib/b/c.js", "/lib/b/d.js"}},
+		{"/li/", []string{}},
+	}
+	for _, test := range tests {
+		actual := []string{}
+		for _, entry := range directory(files, test.Dir) {
+			actual = append(actual, entry.Path)
+		}
+		if !slices.Equal(actual, test.Expected) {
+			t.Errorf("%s: expected %v but got %v", test.Dir, test.Expected, actual)
+		}
+	}
+}
+
+func TestMainFile(t *testing.T) {
+	type Test struct {
+		Main     string
+		Files    []string
+		Expected string
+	}
+	tests := []Test{
+		{"", []string{"/index.js"}, "/index.js"},
+		{"lib/main.js", []string{"/lib/main.js"}, "/lib/main.js"},
+		{"lib/main", []string{"/lib/main.js"}, "/lib/main.js"},
+		{"lib/main", []string{"/lib/main", "/lib/main.js"}, "/lib/main"},
+		{"lib", []string{"/lib/index.js"}, "/lib/index.js"},
+		{"./lib/", []string{"/lib/index.js"}, "/lib/index.js"},
+		{"main.cjs", []string{"/main.cjs"}, "/main.cjs"},
+		{"missing", []string{"/index.js"}, "/missing"},
+	}
+	tarball := storage.NewTarball(storage.Package{Registry: "registry.npmjs.org", Name: "x"}, "x-1.0.0.tgz")
+	for _, test := range tests {
+		files := map[string]string{"package/package.json": fmt.Sprintf(`{"name":"x","version":"1.0.0","main":%q}`, test.Main)}
+		for _, file := range test.Files {
+			files["package"+file] = ""
+		}
+		actual, err := mainFile(tarball, storagetest.NewTarball(t, files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != test.Expected {
+			t.Errorf("%s %v: expected %s but got %s", test.Main, test.Files, test.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/webhook"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// hookRequest is the body sent by npm when adding or updating hooks.
+type hookRequest struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Secret   string `json:"secret"`
+}
+
+// Hooks lists hooks, compatible with npm hook ls.
+func Hooks(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	hooks := cfg.Webhooks.Hooks(r.URL.Query().Get("package"))
+	total := len(hooks)
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		hooks = hooks[min(offset, len(hooks)):]
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		hooks = hooks[:min(limit, len(hooks))]
+	}
+	return writeJSON(w, map[string]interface{}{"objects": hooks, "total": total, "urls": map[string]string{}})
+}
+
+// AddHook creates a hook, compatible with npm hook add.
+func AddHook(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	req := hookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	hook, err := cfg.Webhooks.Add(req.Type, req.Name, req.Endpoint, req.Secret)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return writeJSON(w, hook)
+}
+
+// Hook responds with a single hook.
+func Hook(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	hook, err := cfg.Webhooks.Hook(r.PathValue("id"))
+	if err != nil {
+		return hookStatus(err), err
+	}
+	return writeJSON(w, hook)
+}
+
+// UpdateHook changes the endpoint and secret of a hook, compatible with npm hook update.
+func UpdateHook(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	req := hookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	hook, err := cfg.Webhooks.Update(r.PathValue("id"), req.Endpoint, req.Secret)
+	if err != nil {
+		return hookStatus(err), err
+	}
+	return writeJSON(w, hook)
+}
+
+// DeleteHook removes a hook, compatible with npm hook rm.
+func DeleteHook(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	hook, err := cfg.Webhooks.Delete(r.PathValue("id"))
+	if err != nil {
+		return hookStatus(err), err
+	}
+	return writeJSON(w, hook)
+}
+
+func hookStatus(err error) int {
+	if errors.Is(err, webhook.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, v any) (int, error) {
+	w.Header().Add("Content-Type", "application/json")
+	return http.StatusOK, json.NewEncoder(w).Encode(v)
+}
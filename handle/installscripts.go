@@ -0,0 +1,53 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+type InstallScriptVersion struct {
+	Package string            `json:"package"`
+	Version string            `json:"version"`
+	Scripts map[string]string `json:"scripts"`
+}
+
+// InstallScripts responds with all stashed versions having install scripts.
+func InstallScripts(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	pkgs, err := cfg.Store.Packages()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	versions := []InstallScriptVersion{}
+	for _, pkg := range pkgs {
+		pkmt, err := cfg.Store.GetPackageMetadata(pkg)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		vers := pkmt.VersionList()
+		slices.Sort(vers)
+		for _, v := range vers {
+			meta, _ := pkmt.Versions[v].(map[string]interface{})
+			if !storage.HasInstallScript(meta) {
+				continue
+			}
+			version := InstallScriptVersion{Package: pkg.String(), Version: v, Scripts: map[string]string{}}
+			scripts, _ := meta["scripts"].(map[string]interface{})
+			for _, script := range []string{"preinstall", "install", "postinstall"} {
+				if cmd, found := scripts[script].(string); found {
+					version.Scripts[script] = cmd
+				}
+			}
+			versions = append(versions, version)
+		}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	return http.StatusOK, json.NewEncoder(w).Encode(versions)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"enpeeem/config"
 	"enpeeem/storage"
+	"enpeeem/upstream"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -22,34 +23,81 @@ func PackageMetadata(w http.ResponseWriter, r *http.Request) (int, error) {
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
-
-	// don't use local storage when proxying, otherwise we won't be able to
-	// fetch packages we don't have in the local storage
-	if cfg.ProxyStash {
-		remotePackageMetadata(w, r, cfg, pkg)
-		return http.StatusOK, nil
+	cutoff, err := snapshotTime(cfg, r)
+	if err != nil {
+		return http.StatusBadRequest, err
 	}
 
-	data, err := localPackageMetadata(cfg.Store, pkg)
-	if errors.Is(err, storage.ErrNotFound) {
-		return http.StatusNotFound, err
-	}
+	internal, err := internal(cfg, pkg)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
-	slog.Debug("metadata found locally", "method", r.Method, "url", r.URL, "http_status", http.StatusOK)
-	w.Header().Add("Content-Type", AbbreviatedPackageMetadataContentType)
 
-	if cfg.URLTemplate != nil {
-		data, err = rewriteURLs(data, cfg.URLTemplate)
+	var data []byte
+	// don't use local storage when proxying, otherwise we won't be able to
+	// fetch packages we don't have in the local storage. Requests for writing
+	// always use local storage since that is what will be changed. Internal
+	// packages are never looked up remotely to prevent dependency confusion.
+	local := !cfg.ProxyStash || r.URL.Query().Get("write") == "true" || internal
+	if !local {
+		if err := checkPackage(cfg, pkg); denied(cfg, w, pkg, err) {
+			return http.StatusForbidden, nil
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		var status int
+		if data, status, err = remotePackageMetadata(cfg, pkg); err != nil {
+			// serve the stashed versions while the remote registry is unavailable
+			unavailable := status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+			if !unavailable || !stashed(cfg.Store, pkg) {
+				return status, err
+			}
+			slog.Warn("remote registry unavailable, serving local package metadata", "pkg", pkg.String(), "cause", err)
+			local = true
+		} else {
+			// only versions allowed by the policy and cooldown are fetched
+			if cfg.Fetch != nil {
+				if err := cfg.Fetch.Add(pkg, data); err != nil {
+					slog.Error("error queueing all tarballs", "pkg", pkg.String(), "cause", err)
+				}
+			}
+			w.Header().Add("Content-Type", "application/json")
+		}
+	}
+	if local {
+		data, err = localPackageMetadata(cfg.Store, pkg)
+		if errors.Is(err, storage.ErrNotFound) {
+			return http.StatusNotFound, err
+		}
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		slog.Debug("metadata found locally", "method", r.Method, "url", r.URL, "http_status", http.StatusOK)
+		w.Header().Add("Content-Type", AbbreviatedPackageMetadataContentType)
+
+		if cfg.URLTemplate != nil {
+			data, err = rewriteURLs(data, cfg.URLTemplate)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+	}
+
+	if !cutoff.IsZero() {
+		if data, err = snapshotPackageMetadata(r, data, cutoff); err != nil {
+			return http.StatusInternalServerError, err
+		}
 	}
 	w.Write(data)
 	return http.StatusOK, nil
 }
 
+// stashed returns true if there are tarballs for the package in the local storage.
+func stashed(store storage.Store, pkg storage.Package) bool {
+	tarballs, err := store.Tarballs(pkg)
+	return err == nil && len(tarballs) > 0
+}
+
 func splitPkg(s string) (string, string) {
 	if len(s) < 1 {
 		return "", ""
@@ -64,15 +112,28 @@ func splitPkg(s string) (string, string) {
 	return "", s
 }
 
+// rewriteURLs rewrites the tarball URLs in raw package metadata, other fields
+// like readme are copied as is.
 func rewriteURLs(data []byte, tmpl *template.Template) ([]byte, error) {
-	pkmt := storage.PackageMetadata{}
-	if err := json.Unmarshal(data, &pkmt); err != nil {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
 		return data, err
 	}
+	pkmt := storage.PackageMetadata{}
+	if raw, found := doc["versions"]; found {
+		if err := json.Unmarshal(raw, &pkmt.Versions); err != nil {
+			return data, err
+		}
+	}
 	if err := pkmt.RewriteURLs(tmpl); err != nil {
 		return data, err
 	}
-	return json.Marshal(pkmt)
+	raw, err := json.Marshal(pkmt.Versions)
+	if err != nil {
+		return data, err
+	}
+	doc["versions"] = raw
+	return json.Marshal(doc)
 }
 
 func localPackageMetadata(store storage.Store, pkg storage.Package) ([]byte, error) {
@@ -98,23 +159,57 @@ func localPackageMetadata(store storage.Store, pkg storage.Package) ([]byte, err
 	return data, err
 }
 
-func remotePackageMetadata(w http.ResponseWriter, r *http.Request, cfg config.Config, pkg storage.Package) (int, error) {
-	data, err := storage.FetchPackageMetadataRemotely(pkg)
+// remotePackageMetadata fetches package metadata from the remote registry, saves
+// the publish times, checks for tampering and removes versions denied by the
+// policy or in cooldown. The returned status code is to be used if an error is
+// returned.
+func remotePackageMetadata(cfg config.Config, pkg storage.Package) ([]byte, int, error) {
+	data, fallback, err := storage.FetchPackageMetadataFallback(pkg)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			return http.StatusNotFound, nil
+			return data, http.StatusNotFound, err
 		}
-		return http.StatusInternalServerError, err
+		if errors.Is(err, upstream.ErrCircuitOpen) {
+			return data, http.StatusServiceUnavailable, err
+		}
+		return data, http.StatusBadGateway, err
 	}
-	slog.Debug("metadata fetched remotely", "method", r.Method, "url", r.URL, "http_status", http.StatusOK)
-	if cfg.FetchAll {
-		go func() {
-			if err := FetchAll(cfg, pkg, data); err != nil {
-				slog.Error("error while fetching all tarballs", "cause", err)
-			}
-		}()
+	slog.Debug("metadata fetched remotely", "pkg", pkg.String(), "fallback", fallback)
+	if err := saveTimes(cfg.Store, pkg, data); err != nil {
+		slog.Error("failed to save publish times", "pkg", pkg.String(), "cause", err)
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(data)
-	return http.StatusOK, nil
+	if data, err = checkTampering(cfg, pkg, data, fallback); err != nil {
+		return data, http.StatusInternalServerError, err
+	}
+	if cfg.Policy != nil {
+		if data, err = cfg.Policy.Filter(pkg, data); err != nil {
+			return data, http.StatusInternalServerError, err
+		}
+	}
+	if cfg.Cooldown != nil {
+		if data, err = cfg.Cooldown.Filter(pkg, data); err != nil {
+			return data, http.StatusInternalServerError, err
+		}
+	}
+	return data, http.StatusOK, nil
+}
+
+// saveTimes keeps the publish times from remote package metadata so they can be
+// used when the package is indexed locally. Times are only kept for packages
+// with stashed tarballs.
+func saveTimes(store storage.Store, pkg storage.Package, data []byte) error {
+	times, err := remoteTimes(data)
+	if err != nil || len(times) == 0 {
+		return err
+	}
+	return storage.PutTimes(store, pkg, times)
+}
+
+// remoteTimes returns the time field of remote package metadata.
+func remoteTimes(data []byte) (map[string]string, error) {
+	pkmt := struct {
+		Time map[string]string `json:"time"`
+	}{}
+	err := json.Unmarshal(data, &pkmt)
+	return pkmt.Time, err
 }
@@ -1,6 +1,10 @@
 package handle
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+	"text/template"
+)
 
 func TestSplitPkg(t *testing.T) {
 	type Test struct {
@@ -27,3 +31,30 @@ func TestSplitPkg(t *testing.T) {
 		}
 	}
 }
+
+func TestRewriteURLs(t *testing.T) {
+	data := []byte(`{"name": "left-pad", "readme": "keep me", "versions": {"1.0.0": {"dist": {"tarball": "https://registry.npmjs.org/left-pad/-/left-pad-1.0.0.tgz"}}}}`)
+	tmpl := template.Must(template.New("test").Parse("http://localhost:8080/{{.Package.Name}}/-/{{.Name}}"))
+	actual, err := rewriteURLs(data, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := struct {
+		Readme   string `json:"readme"`
+		Versions map[string]struct {
+			Dist struct {
+				Tarball string `json:"tarball"`
+			} `json:"dist"`
+		} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(actual, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Readme != "keep me" {
+		t.Errorf("expected readme to be kept but got %q", doc.Readme)
+	}
+	expected := "http://localhost:8080/left-pad/-/left-pad-1.0.0.tgz"
+	if tarball := doc.Versions["1.0.0"].Dist.Tarball; tarball != expected {
+		t.Errorf("expected %s but got %s", expected, tarball)
+	}
+}
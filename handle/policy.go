@@ -0,0 +1,84 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/policy"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// ReloadPolicy reads the policy file again.
+func ReloadPolicy(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Policy == nil {
+		return http.StatusNotFound, fmt.Errorf("policy is not enabled")
+	}
+	if err := cfg.Policy.Reload(); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// checkPackage returns a policy.Denial if the package is denied by the policy or
+// it's name looks like a typosquat.
+func checkPackage(cfg config.Config, pkg storage.Package) error {
+	if cfg.Policy == nil {
+		return nil
+	}
+	if err := cfg.Policy.CheckPackage(pkg); err != nil {
+		return err
+	}
+	return cfg.Policy.CheckTyposquat(pkg)
+}
+
+// internal returns true if the package must never be fetched from the remote
+// registry. Packages with versions published directly to enpeeem are internal
+// even without a policy.
+func internal(cfg config.Config, pkg storage.Package) (bool, error) {
+	if cfg.Policy != nil {
+		return cfg.Policy.Internal(pkg)
+	}
+	published, err := storage.GetPublished(cfg.Store, pkg)
+	return len(published) > 0, err
+}
+
+// checkPolicy returns a policy.Denial if the version in the tarball is denied
+// by the policy.
+func checkPolicy(cfg config.Config, tarball storage.Tarball, data []byte) error {
+	if cfg.Policy == nil {
+		return nil
+	}
+	pkgJson, err := tarball.PackageJsonFromTar(data)
+	if err != nil {
+		return err
+	}
+	meta := map[string]interface{}{}
+	if err := json.Unmarshal(pkgJson, &meta); err != nil {
+		return err
+	}
+	if err := storage.DetectInstallScript(tarball, data, meta); err != nil {
+		return err
+	}
+	return cfg.Policy.CheckVersion(tarball.Package(), tarball.Version(), meta, int64(len(data)))
+}
+
+// denied writes a response npm shows to the user if err is a policy denial. Returns
+// false if err is not a denial.
+func denied(cfg config.Config, w http.ResponseWriter, pkg storage.Package, err error) bool {
+	var denial *policy.Denial
+	if !errors.As(err, &denial) {
+		return false
+	}
+	slog.Info("denied by policy", "rule", denial.Rule, "pkg", pkg.String(), "version", denial.Version, "reason", denial.Reason)
+	cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventDenied, Registry: pkg.Registry, Name: denial.Package, Version: denial.Version, Change: map[string]string{"rule": denial.Rule, "reason": denial.Reason}})
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": denial.Error()})
+	return true
+}
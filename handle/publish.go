@@ -0,0 +1,204 @@
+package handle
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+)
+
+// packument is the document npm sends when publishing, deprecating or
+// unpublishing versions of a package.
+type packument struct {
+	DistTags    map[string]string                 `json:"dist-tags"`
+	Versions    map[string]map[string]interface{} `json:"versions"`
+	Attachments map[string]struct {
+		Data string `json:"data"`
+	} `json:"_attachments"`
+}
+
+// UpdatePackage handles the npm publish, deprecate and unpublish flows. Tarballs
+// attached to the request are published. Otherwise the versions are compared to
+// local package metadata and the deprecated field is updated. Versions missing
+// in the request are only unpublished for requests with a revision, which npm
+// sends when unpublishing, other requests must contain every version.
+func UpdatePackage(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	doc := packument{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if len(doc.Versions) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("no versions in package document of %s", pkg.String())
+	}
+
+	events := []webhook.Event{}
+	if len(doc.Attachments) > 0 {
+		published, status, err := publish(cfg.Store, pkg, doc)
+		if err != nil {
+			return status, err
+		}
+		for _, v := range published {
+			events = append(events, webhook.Event{Event: webhook.EventPublish, Registry: pkg.Registry, Name: pkg.FullName(), Version: v, Payload: doc.Versions[v], Change: map[string]string{"version": v}})
+		}
+	} else {
+		pkmt, status, err := localMetadata(cfg.Store, pkg)
+		if err != nil {
+			return status, err
+		}
+		unpublish := r.PathValue("rev") != ""
+		dropped := []string{}
+		deprecations := map[string]string{}
+		for _, v := range pkmt.VersionList() {
+			version, found := doc.Versions[v]
+			if !found {
+				if !unpublish {
+					return http.StatusBadRequest, fmt.Errorf("version %s of %s is missing in package document", v, pkg.String())
+				}
+				dropped = append(dropped, v)
+				continue
+			}
+			if msg, _ := version["deprecated"].(string); msg != "" {
+				deprecations[v] = msg
+			}
+		}
+		for _, v := range dropped {
+			slog.Info("unpublishing version", "pkg", pkg.String(), "version", v)
+			if err := cfg.Store.DeleteTarball(storage.NewTarball(pkg, versionTarballName(pkg, v))); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return deleteStatus(err), err
+			}
+			events = append(events, webhook.Event{Event: webhook.EventUnpublish, Registry: pkg.Registry, Name: pkg.FullName(), Version: v, Change: map[string]string{"version": v}})
+		}
+		if err := storage.PutDeprecations(cfg.Store, pkg, deprecations); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	if _, err := cfg.Store.Index(pkg); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, e := range events {
+		cfg.Webhooks.Emit(e)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true}`))
+	return http.StatusOK, nil
+}
+
+// publish saves tarballs attached to the document and sets the given dist-tags
+// for the published versions. The published versions are returned.
+func publish(store storage.Store, pkg storage.Package, doc packument) ([]string, int, error) {
+	published := []string{}
+	for tag := range doc.DistTags {
+		if err := storage.ValidateDistTag(tag); err != nil {
+			return published, http.StatusBadRequest, err
+		}
+	}
+	tags := map[string]string{}
+	for v, version := range doc.Versions {
+		// npm names attachments of scoped packages @scope/name-version.tgz
+		attachment, found := doc.Attachments[fmt.Sprintf("%s-%s.tgz", pkg.FullName(), v)]
+		if !found {
+			dist, _ := version["dist"].(map[string]interface{})
+			tarballURL, _ := dist["tarball"].(string)
+			if attachment, found = doc.Attachments[path.Base(tarballURL)]; !found {
+				continue
+			}
+		}
+		tarball := storage.NewTarball(pkg, versionTarballName(pkg, v))
+		if _, err := store.GetTarball(tarball); err == nil {
+			return published, http.StatusConflict, fmt.Errorf("cannot publish over existing version %s of %s", v, pkg.String())
+		}
+		data, err := base64.StdEncoding.DecodeString(attachment.Data)
+		if err != nil {
+			return published, http.StatusBadRequest, err
+		}
+		if err := storage.VerifyTarball(tarball, data, ""); err != nil {
+			return published, http.StatusBadRequest, err
+		}
+		slog.Info("publishing version", "pkg", pkg.String(), "version", v)
+		if err := store.PutTarball(tarball, data); err != nil {
+			return published, http.StatusInternalServerError, err
+		}
+		if err := storage.AddPublished(store, pkg, v); err != nil {
+			return published, http.StatusInternalServerError, err
+		}
+		published = append(published, v)
+		for tag, tagVersion := range doc.DistTags {
+			if tagVersion == v {
+				tags[tag] = v
+			}
+		}
+	}
+	err := storage.UpdateDistTags(store, pkg, func(current map[string]string) {
+		for tag, v := range tags {
+			current[tag] = v
+		}
+	})
+	return published, http.StatusOK, err
+}
+
+// Unpublish removes a single tarball, or the entire package if no tarball is given.
+func Unpublish(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	if r.PathValue("scope") != "" {
+		s = r.PathValue("scope")
+	}
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if r.PathValue("tarball") == "" {
+		slog.Info("unpublishing package", "pkg", pkg.String())
+		if err := cfg.Store.DeletePackage(pkg); err != nil {
+			return deleteStatus(err), err
+		}
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventUnpublish, Registry: pkg.Registry, Name: pkg.FullName()})
+	} else {
+		tarball := storage.NewTarball(pkg, r.PathValue("tarball"))
+		slog.Info("unpublishing tarball", "tarball", tarball.String())
+		// npm removes the version from the metadata before deleting the tarball
+		// so it might already be gone
+		err := cfg.Store.DeleteTarball(tarball)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return deleteStatus(err), err
+		}
+		deleted := err == nil
+		if _, err := cfg.Store.Index(pkg); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if deleted {
+			cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventUnpublish, Registry: pkg.Registry, Name: pkg.FullName(), Version: tarball.Version(), Change: map[string]string{"version": tarball.Version()}})
+		}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true}`))
+	return http.StatusOK, nil
+}
+
+// deleteStatus returns the response status for an error deleting from the store,
+// packages in read-only layers can't be unpublished.
+func deleteStatus(err error) int {
+	if errors.Is(err, storage.ErrReadOnly) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// versionTarballName returns the tarball filename npm uses for a version.
+func versionTarballName(pkg storage.Package, version string) string {
+	return fmt.Sprintf("%s-%s.tgz", pkg.Name, version)
+}
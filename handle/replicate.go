@@ -0,0 +1,91 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/replicate"
+	"enpeeem/storage"
+	"errors"
+	"net/http"
+	"path"
+)
+
+// ReplicationPackage responds with everything a replica needs to copy a package:
+// the stashed tarballs with their integrity and the package assets.
+func ReplicationPackage(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	tarballs, err := cfg.Store.Tarballs(pkg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(tarballs) == 0 {
+		return http.StatusNotFound, storage.ErrNotFound
+	}
+	pkmt, err := cfg.Store.GetPackageMetadata(pkg)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return http.StatusInternalServerError, err
+	}
+	integrity := map[string]string{}
+	for v := range pkmt.Versions {
+		version, _ := pkmt.Versions[v].(map[string]interface{})
+		dist, _ := version["dist"].(map[string]interface{})
+		if tarballURL, _ := dist["tarball"].(string); tarballURL != "" {
+			integrity[path.Base(tarballURL)] = pkmt.Integrity(v)
+		}
+	}
+
+	doc := replicate.Package{Tarballs: map[string]string{}, Assets: map[string][]byte{}}
+	for _, tarball := range tarballs {
+		doc.Tarballs[tarball.Name] = integrity[tarball.Name]
+	}
+	for _, name := range replicate.Assets {
+		data, err := cfg.Store.GetPackageAsset(pkg, name)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		doc.Assets[name] = data
+	}
+	// publish times of versions not fetched remotely are only in the metadata
+	times, err := storage.GetTimes(cfg.Store, pkg)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for v, t := range pkmt.Time {
+		if _, found := times[v]; !found && v != "created" && v != "modified" {
+			times[v] = t
+		}
+	}
+	if doc.Assets[storage.TimeAssetName], err = json.Marshal(times); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeJSON(w, doc)
+}
+
+// RawTarball serves a stashed tarball from any registry, without fetching it
+// remotely or applying policies.
+func RawTarball(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	data, err := cfg.Store.GetTarball(storage.NewTarball(pkg, r.PathValue("tarball")))
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	w.Write(data)
+	return http.StatusOK, nil
+}
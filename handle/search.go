@@ -0,0 +1,102 @@
+package handle
+
+import (
+	"enpeeem/config"
+	"enpeeem/storage"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSearchSize = 20
+	maxSearchSize     = 250
+)
+
+type searchPackage struct {
+	Name        string            `json:"name"`
+	Scope       string            `json:"scope"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+	Date        string            `json:"date,omitempty"`
+	Keywords    []string          `json:"keywords"`
+	Maintainers []any             `json:"maintainers"`
+	Links       map[string]string `json:"links"`
+}
+
+type searchObject struct {
+	Package     searchPackage  `json:"package"`
+	Score       map[string]any `json:"score"`
+	SearchScore float64        `json:"searchScore"`
+}
+
+// Search responds to npm search with the stashed packages of the registry
+// matching the text in their name or description, it requires the SQL index.
+func Search(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.SQLIndex == nil {
+		return http.StatusNotFound, fmt.Errorf("search requires the sql index")
+	}
+	query := r.URL.Query()
+	size := defaultSearchSize
+	if s := query.Get("size"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size < 1 {
+			return http.StatusBadRequest, fmt.Errorf("invalid size %s", s)
+		}
+		size = min(size, maxSearchSize)
+	}
+	from := 0
+	if f := query.Get("from"); f != "" {
+		var err error
+		if from, err = strconv.Atoi(f); err != nil || from < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid from %s", f)
+		}
+	}
+	// the registry host is taken from a package of the registry
+	registry, err := storage.NewPackage(cfg.Registry, "", "")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	pkgs, total, err := cfg.SQLIndex.Search(registry.Registry, query.Get("text"), from, size)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	objects := []searchObject{}
+	for _, pkg := range pkgs {
+		scope := "unscoped"
+		if pkg.Scope != "" {
+			scope = pkg.Scope[1:]
+		}
+		objects = append(objects, searchObject{
+			Package: searchPackage{
+				Name:        pkg.FullName(),
+				Scope:       scope,
+				Version:     pkg.Latest,
+				Description: pkg.Description,
+				Date:        pkg.Modified,
+				Keywords:    []string{},
+				Maintainers: []any{},
+				Links:       map[string]string{},
+			},
+			Score:       map[string]any{"final": 1, "detail": map[string]float64{"quality": 1, "popularity": 1, "maintenance": 1}},
+			SearchScore: 1,
+		})
+	}
+	return writeJSON(w, map[string]any{"objects": objects, "total": total, "time": time.Now().UTC().Format(time.RFC1123)})
+}
+
+// Stats responds with the number of packages and versions and the size of the
+// stash per registry, it requires the SQL index.
+func Stats(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.SQLIndex == nil {
+		return http.StatusNotFound, fmt.Errorf("stats require the sql index")
+	}
+	stats, err := cfg.SQLIndex.Stats()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeJSON(w, stats)
+}
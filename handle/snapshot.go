@@ -0,0 +1,88 @@
+package handle
+
+import (
+	"enpeeem/config"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// snapshotTime returns the cutoff time for requests using the snapshot path
+// prefix, or the configured snapshot time. A zero time is returned if the
+// request is not for a snapshot.
+func snapshotTime(cfg config.Config, r *http.Request) (time.Time, error) {
+	date := r.PathValue("date")
+	if date == "" {
+		return cfg.Snapshot, nil
+	}
+	return ParseSnapshotTime(date)
+}
+
+// ParseSnapshotTime parses a date, like 2024-06-01, or a RFC 3339 timestamp. Dates
+// include the entire day.
+func ParseSnapshotTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid snapshot time %s, expected a date like 2024-06-01 or a RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+// snapshotPackageMetadata removes versions published after cutoff. Tarball URL's are
+// rewritten to use the snapshot path prefix if the request used it.
+func snapshotPackageMetadata(r *http.Request, data []byte, cutoff time.Time) ([]byte, error) {
+	data, err := storage.FilterVersions(data, func(_ string, published time.Time, found bool) bool {
+		return found && !published.After(cutoff)
+	})
+	if err != nil || r.PathValue("date") == "" {
+		return data, err
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	tmpl, err := template.New("snapshot").Parse(fmt.Sprintf("%s://%s/@snapshot/%s/{{if .Package.Scope}}{{.Package.Scope}}/{{end}}{{.Package.Name}}/-/{{.Name}}", scheme, r.Host, r.PathValue("date")))
+	if err != nil {
+		return data, err
+	}
+	return rewriteURLs(data, tmpl)
+}
+
+// publishedAfter returns true if the tarball version was published after cutoff
+// or if it's publish time is unknown. When proxying, the publish time of
+// versions not yet stashed is taken from the remote package metadata.
+func publishedAfter(cfg config.Config, tarball storage.Tarball, cutoff time.Time) (bool, error) {
+	pkmt, err := cfg.Store.GetPackageMetadata(tarball.Package())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return true, err
+	}
+	published, found := pkmt.PublishTime(tarball.Version())
+	if !found {
+		times, err := storage.GetTimes(cfg.Store, tarball.Package())
+		if err != nil {
+			return true, err
+		}
+		pkmt.Time = times
+		published, found = pkmt.PublishTime(tarball.Version())
+	}
+	if !found && cfg.ProxyStash {
+		data, err := storage.FetchPackageMetadataRemotely(tarball.Package())
+		if errors.Is(err, storage.ErrNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		if pkmt.Time, err = remoteTimes(data); err != nil {
+			return true, err
+		}
+		published, found = pkmt.PublishTime(tarball.Version())
+	}
+	return !found || published.After(cutoff), nil
+}
@@ -0,0 +1,43 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"enpeeem/webhook"
+	"net/http"
+)
+
+// Tampering responds with all tampering detected in the remote registry.
+func Tampering(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	pkgs, err := cfg.Store.Packages()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tampering := []storage.Tampering{}
+	for _, pkg := range pkgs {
+		t, err := storage.GetTampering(cfg.Store, pkg)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		tampering = append(tampering, t...)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	return http.StatusOK, json.NewEncoder(w).Encode(tampering)
+}
+
+// checkTampering compares remote package metadata with the recorded and stashed
+// hashes. Hashes of tampered versions are replaced so the original tarballs keep
+// being served, newly detected tampering is sent to webhooks. Hashes in metadata
+// from a mirror aren't recorded.
+func checkTampering(cfg config.Config, pkg storage.Package, data []byte, fallback bool) ([]byte, error) {
+	tampered, detected, err := storage.DetectTampering(cfg.Store, pkg, data, !fallback)
+	if err != nil {
+		return data, err
+	}
+	for _, t := range detected {
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventTampering, Registry: pkg.Registry, Name: t.Package, Version: t.Version, Payload: t})
+	}
+	return storage.RestoreDists(data, tampered)
+}
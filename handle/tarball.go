@@ -2,10 +2,15 @@ package handle
 
 import (
 	"enpeeem/config"
+	"enpeeem/cooldown"
 	"enpeeem/storage"
+	"enpeeem/upstream"
+	"enpeeem/webhook"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 func Tarball(w http.ResponseWriter, r *http.Request) (int, error) {
@@ -15,21 +20,47 @@ func Tarball(w http.ResponseWriter, r *http.Request) (int, error) {
 		return http.StatusInternalServerError, err
 	}
 	tarball := storage.NewTarball(pkg, r.PathValue("tarball"))
+	cutoff, err := snapshotTime(cfg, r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if !cutoff.IsZero() {
+		after, err := publishedAfter(cfg, tarball, cutoff)
+		if errors.Is(err, upstream.ErrCircuitOpen) {
+			return http.StatusServiceUnavailable, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if after {
+			return http.StatusNotFound, fmt.Errorf("%s was published after snapshot time %s", tarball.String(), cutoff.Format(time.RFC3339))
+		}
+	}
 	data, err := cfg.Store.GetTarball(tarball)
 	if errors.Is(err, storage.ErrNotFound) {
 		if !cfg.ProxyStash {
-			return http.StatusNotFound, nil
+			return http.StatusNotFound, err
 		}
-		data, err = tarball.FetchRemotely()
+		data, err = fetchAndSave(cfg, tarball)
 		if err != nil {
+			if denied(cfg, w, pkg, err) {
+				return http.StatusForbidden, nil
+			}
 			if errors.Is(err, storage.ErrNotFound) {
-				return http.StatusNotFound, nil
+				return http.StatusNotFound, err
+			}
+			if errors.Is(err, cooldown.ErrCooldown) {
+				return http.StatusForbidden, err
+			}
+			if errors.Is(err, storage.ErrIntegrity) {
+				return http.StatusBadGateway, err
+			}
+			if errors.Is(err, upstream.ErrCircuitOpen) {
+				return http.StatusServiceUnavailable, err
 			}
 			return http.StatusInternalServerError, err
 		}
-		if err := cfg.Store.PutTarball(tarball, data); err != nil {
-			return http.StatusInternalServerError, err
-		}
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventStash, Registry: pkg.Registry, Name: pkg.FullName(), Version: tarball.Version(), Change: map[string]string{"tarball": tarball.Name}})
 		if _, err := cfg.Store.Index(pkg); err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -0,0 +1,32 @@
+package handle
+
+import (
+	"enpeeem/storage"
+	"fmt"
+	"net/http"
+)
+
+// Upstream responds with the circuit breaker state and request counts of all
+// remote registry hosts.
+func Upstream(w http.ResponseWriter, r *http.Request) (int, error) {
+	return writeJSON(w, storage.Upstream.Hosts())
+}
+
+// ResetUpstream closes the circuit breaker of a host so requests are sent again
+// without waiting for the breaker timeout.
+func ResetUpstream(w http.ResponseWriter, r *http.Request) (int, error) {
+	if !storage.Upstream.Reset(r.PathValue("host")) {
+		return http.StatusNotFound, fmt.Errorf("no requests sent to %s", r.PathValue("host"))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// Mirrors responds with the health of all upstream registries in the configured
+// order.
+func Mirrors(w http.ResponseWriter, r *http.Request) (int, error) {
+	if storage.Mirrors == nil {
+		return http.StatusNotFound, fmt.Errorf("no mirrors configured")
+	}
+	return writeJSON(w, storage.Mirrors.Status())
+}
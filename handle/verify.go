@@ -0,0 +1,58 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"enpeeem/verify"
+	"errors"
+	"net/http"
+)
+
+// Verify validates stashed tarballs for a single package and responds with the
+// tarballs that failed verification. Without a package all packages are verified
+// in a background job and the job is returned, its progress and results are
+// read with VerifyJob.
+func Verify(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	opts := storage.VerifyOptions{
+		Upstream:   cfg.ProxyStash,
+		Quarantine: r.URL.Query().Get("quarantine") == "true",
+		Refetch:    cfg.ProxyStash && r.URL.Query().Get("refetch") == "true",
+	}
+
+	if r.PathValue("pkg") == "" {
+		job, err := cfg.Verify.Start(opts)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		return http.StatusAccepted, json.NewEncoder(w).Encode(job)
+	}
+
+	s, p := splitPkg(r.PathValue("pkg"))
+	pkg, err := storage.NewPackage(r.PathValue("registry"), s, p)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	results, err := storage.Verify(cfg.Store, pkg, opts)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if results == nil {
+		results = []storage.VerifyResult{}
+	}
+	return writeJSON(w, results)
+}
+
+// VerifyJob responds with the progress and failed tarballs of a job verifying all
+// packages.
+func VerifyJob(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	job, err := cfg.Verify.Job(r.PathValue("id"))
+	if errors.Is(err, verify.ErrNotFound) {
+		return http.StatusNotFound, err
+	}
+	return writeJSON(w, job)
+}
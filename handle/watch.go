@@ -0,0 +1,147 @@
+package handle
+
+import (
+	"encoding/json"
+	"enpeeem/config"
+	"enpeeem/storage"
+	"enpeeem/watch"
+	"enpeeem/webhook"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+)
+
+// Watchlist responds with all watched packages and their last sync.
+func Watchlist(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Watchlist == nil {
+		return http.StatusNotFound, fmt.Errorf("watchlist is not enabled")
+	}
+	return writeJSON(w, cfg.Watchlist.List())
+}
+
+// WatchPackage adds a package to the watchlist, the body can set the versions to
+// download with {"versions": "<semver range>"}.
+func WatchPackage(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Watchlist == nil {
+		return http.StatusNotFound, fmt.Errorf("watchlist is not enabled")
+	}
+	req := struct {
+		Versions string `json:"versions"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return http.StatusBadRequest, err
+	}
+	entry, err := cfg.Watchlist.Add(r.PathValue("pkg"), req.Versions)
+	if errors.Is(err, watch.ErrConfigured) {
+		return http.StatusConflict, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return writeJSON(w, entry)
+}
+
+// UnwatchPackage removes a package from the watchlist.
+func UnwatchPackage(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Watchlist == nil {
+		return http.StatusNotFound, fmt.Errorf("watchlist is not enabled")
+	}
+	err := cfg.Watchlist.Remove(r.PathValue("pkg"))
+	if errors.Is(err, watch.ErrNotFound) {
+		return http.StatusNotFound, err
+	}
+	if errors.Is(err, watch.ErrConfigured) {
+		return http.StatusConflict, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// SyncWatchlist starts syncing all watched packages without waiting for the
+// next scheduled sync.
+func SyncWatchlist(w http.ResponseWriter, r *http.Request) (int, error) {
+	cfg := config.FromContext(r)
+	if cfg.Watchlist == nil {
+		return http.StatusNotFound, fmt.Errorf("watchlist is not enabled")
+	}
+	cfg.Watchlist.Sync()
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted, nil
+}
+
+// SyncWatched fetches the remote package metadata of a watched package and
+// downloads the missing versions for which keep returns true, versions denied by
+// the policy or in cooldown are skipped. Returns the number of downloaded tarballs.
+func SyncWatched(cfg config.Config, name string, keep func(version, latest string) bool) (int, error) {
+	s, p := splitPkg(name)
+	pkg, err := storage.NewPackage(cfg.Registry, s, p)
+	if err != nil {
+		return 0, err
+	}
+	if internal, err := internal(cfg, pkg); err != nil {
+		return 0, err
+	} else if internal {
+		return 0, fmt.Errorf("%s is internal and never fetched remotely", pkg.String())
+	}
+	if err := checkPackage(cfg, pkg); err != nil {
+		return 0, err
+	}
+	data, _, err := remotePackageMetadata(cfg, pkg)
+	if err != nil {
+		return 0, err
+	}
+	pkmt := storage.PackageMetadata{}
+	if err := json.Unmarshal(data, &pkmt); err != nil {
+		return 0, err
+	}
+	existingTarballs, err := cfg.Store.Tarballs(pkg)
+	if err != nil {
+		return 0, err
+	}
+	versions := []string{}
+	for v := range pkmt.Versions {
+		if keep(v, pkmt.DistTags["latest"]) {
+			versions = append(versions, v)
+		}
+	}
+	sort.Strings(versions)
+
+	fetched := 0
+	errs := []error{}
+	for _, v := range versions {
+		version, _ := pkmt.Versions[v].(map[string]interface{})
+		dist, _ := version["dist"].(map[string]interface{})
+		tarballURL, _ := dist["tarball"].(string)
+		if tarballURL == "" {
+			continue
+		}
+		tarball := storage.NewTarball(pkg, path.Base(tarballURL))
+		if slices.Contains(existingTarballs, tarball) {
+			continue
+		}
+		slog.Info("downloading watched tarball", "url", tarball.RemoteURL())
+		if _, err := fetchAndSave(cfg, tarball); err != nil {
+			errs = append(errs, fmt.Errorf("error downloading %s: %w", tarball.String(), err))
+			continue
+		}
+		cfg.Webhooks.Emit(webhook.Event{Event: webhook.EventStash, Registry: pkg.Registry, Name: pkg.FullName(), Version: tarball.Version(), Change: map[string]string{"tarball": tarball.Name}})
+		fetched++
+	}
+	if fetched > 0 {
+		if _, err := cfg.Store.Index(pkg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return fetched, errors.Join(errs...)
+}
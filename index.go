@@ -1,16 +1,16 @@
 package main
 
 import (
+	"enpeeem/reindex"
 	"enpeeem/storage"
 	"log/slog"
 	"os"
 
-	"github.com/alitto/pond"
 	"github.com/schollz/progressbar/v3"
 )
 
 func reindexAll(store storage.Store, pkgthreads int) int {
-	pkgs, err := store.Packages()
+	pkgs, err := storage.PackagesOnDisk(store)
 	if err != nil {
 		slog.Error("failed to list packages", "cause", err)
 	}
@@ -21,16 +21,13 @@ func reindexAll(store storage.Store, pkgthreads int) int {
 		bar = progressbar.DefaultSilent(int64(len(pkgs)))
 	}
 	exitCode := 0
-	pool := pond.New(pkgthreads, 0)
-	for _, pkg := range pkgs {
-		pool.Submit(func() {
-			if indexPackage(store, pkg) != 0 {
-				exitCode = 1
-			}
-			bar.Add(1)
-		})
-	}
-	pool.StopAndWait()
+	reindex.All(store, pkgs, pkgthreads, func(pkg storage.Package, err error) {
+		if err != nil {
+			slog.Error("error indexing package", "cause", err, "package", pkg.String())
+			exitCode = 1
+		}
+		bar.Add(1)
+	})
 	return exitCode
 }
 
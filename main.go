@@ -1,47 +1,134 @@
 package main
 
 import (
+	"context"
+	"enpeeem/changes"
 	"enpeeem/config"
+	"enpeeem/cooldown"
+	"enpeeem/fetch"
 	"enpeeem/handle"
+	"enpeeem/policy"
+	"enpeeem/reindex"
+	"enpeeem/replicate"
 	"enpeeem/storage"
+	"enpeeem/upstream"
+	"enpeeem/verify"
+	"enpeeem/watch"
+	"enpeeem/web"
+	"enpeeem/webhook"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	addr         string
-	cfg          config.Config
-	fetchAll     bool
-	indexAll     bool
-	indexPkg     string
-	metadir      string
-	pkgthreads   int
-	printVersion bool
-	progress     bool
-	proxystash   bool
-	registry     string
-	urltemplate  string
-	storageDir   string
-	verbose      bool
-	version      = "SET VERSION IN MAKEFILE"
+	addr                string
+	alertWebhook        string
+	cfg                 config.Config
+	cooldownDays        int
+	cooldownOvr         string
+	fetchAll            bool
+	fetchMaxAge         int
+	fetchPrerelease     bool
+	fetchWorkers        int
+	indexAll            bool
+	indexPkg            string
+	layers              string
+	cas                 bool
+	casMigrate          bool
+	casGC               bool
+	sqlIndex            bool
+	sqlIndexRebuild     bool
+	adminToken          string
+	metadir             string
+	pkgthreads          int
+	preferLatency       bool
+	policyFile          string
+	printVersion        bool
+	progress            bool
+	proxystash          bool
+	quarantine          bool
+	refetch             bool
+	registry            string
+	replicateFrom       string
+	replicateOnce       bool
+	replicatePackages   string
+	replicateRegistries string
+	replicateScopes     string
+	replicateToken      string
+	snapshot            string
+	statedir            string
+	storageDir          string
+	upstreamBreaker     int
+	upstreamMaxConns    int
+	upstreamOpen        time.Duration
+	upstreamRetries     int
+	urltemplate         string
+	verbose             bool
+	verifyAllPkg        bool
+	verifyPkg           string
+	version             = "SET VERSION IN MAKEFILE"
+	watchInterval       time.Duration
+	watchlistFile       string
+	webhookSecret       string
+	webhookURL          string
 )
 
 func init() {
 	flag.StringVar(&addr, "addr", ":8080", "network address of local registry")
-	flag.StringVar(&registry, "registry", "https://registry.npmjs.org", "remote npm registry to use when the flag proxystash is set")
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token required for the admin API, the admin API is disabled without it")
+	flag.StringVar(&registry, "registry", "https://registry.npmjs.org", "remote npm registry to use when the flag proxystash is set, comma separated mirrors are tried when it fails")
+	flag.BoolVar(&preferLatency, "registry-prefer-latency", false, "prefer the healthy registry mirror with the lowest latency instead of the first one")
+	flag.BoolVar(&cas, "cas", false, "store each distinct tarball once and link tarballs of all registries and packages to it")
+	flag.BoolVar(&casMigrate, "cas-migrate", false, "convert the tarballs in path to content-addressable storage and exit")
+	flag.BoolVar(&casGC, "cas-gc", false, "remove content-addressable storage blobs no tarball links to and exit")
 	flag.BoolVar(&indexAll, "index-all", false, "index all packages")
 	flag.BoolVar(&progress, "progress", false, "show progress where applicable")
 	flag.BoolVar(&printVersion, "version", false, "print version")
 	flag.BoolVar(&verbose, "verbose", false, "print debug information")
-	flag.BoolVar(&fetchAll, "fetch-all", false, "download all tarbal versions at once if a tarball is not found locally")
+	flag.BoolVar(&fetchAll, "fetch-all", false, "download all tarball versions in the background when package metadata is fetched remotely")
+	flag.IntVar(&fetchWorkers, "fetch-workers", 5, "number of packages downloaded at the same time by fetch-all")
+	flag.BoolVar(&fetchPrerelease, "fetch-skip-prerelease", false, "don't download prerelease versions with fetch-all")
+	flag.IntVar(&fetchMaxAge, "fetch-max-age", 0, "don't download versions published more than given number of days ago with fetch-all")
 	flag.StringVar(&indexPkg, "index", "", "index with given package URI, example registry.npmjs.org/@types/react")
 	flag.BoolVar(&proxystash, "proxystash", false, "run in proxy mode to proxy and download tarballs if not available locally")
+	flag.StringVar(&layers, "layers", "", "comma separated read-only storage paths read after path, in order, with metadata stored with the tarballs")
 	flag.StringVar(&metadir, "metadir", "", "metadata file directory, by default files are stored together with the tarballs")
+	flag.IntVar(&upstreamRetries, "upstream-retries", 2, "number of retries for failed requests to the remote registry")
+	flag.IntVar(&upstreamMaxConns, "upstream-max-conns", 16, "maximum number of concurrent requests to a remote registry host")
+	flag.IntVar(&upstreamBreaker, "upstream-breaker", 5, "number of consecutive failed requests before requests to a remote registry host fail fast")
+	flag.DurationVar(&upstreamOpen, "upstream-breaker-timeout", 30*time.Second, "time requests fail fast before the remote registry host is tried again")
 	flag.StringVar(&urltemplate, "urltemplate", "", "Go template to rewrite tarball URL's in package metadata requests")
-	flag.IntVar(&pkgthreads, "pkgthreads", 5, "number of packages to process at the same time when indexing all packages")
+	flag.StringVar(&snapshot, "snapshot", "", "only serve versions published before given date or RFC 3339 timestamp, example 2024-06-01")
+	flag.IntVar(&cooldownDays, "cooldown", 0, "number of days before new versions from the remote registry are served when the flag proxystash is set")
+	flag.StringVar(&cooldownOvr, "cooldown-override", "", "cooldown days for scopes or packages, example @internal=0,react=14")
+	flag.StringVar(&policyFile, "policy", "", "policy file with rules for packages allowed from the remote registry, reloaded on SIGHUP")
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "URL receiving a JSON POST request when the remote registry changes the integrity of a version")
+	flag.StringVar(&replicateFrom, "replicate", "", "URL of a primary enpeeem instance to replicate packages from, example http://primary:8080")
+	flag.BoolVar(&replicateOnce, "replicate-once", false, "exit when all changes from the primary are replicated instead of following changes")
+	flag.StringVar(&replicateRegistries, "replicate-registries", "", "comma separated registries to replicate, example registry.npmjs.org")
+	flag.StringVar(&replicateScopes, "replicate-scopes", "", "comma separated scopes to replicate, example @types,@babel")
+	flag.StringVar(&replicateToken, "replicate-token", "", "admin token of the primary enpeeem instance to replicate from")
+	flag.StringVar(&replicatePackages, "replicate-packages", "", "comma separated package name globs to replicate, example react*,@types/*")
+	flag.StringVar(&watchlistFile, "watchlist", "", "JSON file with packages synced from the remote registry on a schedule when the flag proxystash is set")
+	flag.DurationVar(&watchInterval, "watch-interval", time.Hour, "time between syncs of watched packages")
+	flag.BoolVar(&sqlIndex, "sqlindex", false, "keep an SQLite index of packages and versions in the state directory for fast listing, search and stats")
+	flag.BoolVar(&sqlIndexRebuild, "sqlindex-rebuild", false, "rebuild the SQLite index from the packages in path and exit")
+	flag.StringVar(&statedir, "statedir", "", "directory for webhooks and other state, by default .enpeeem in the storage path")
+	flag.StringVar(&webhookURL, "webhook", "", "URL receiving a JSON POST request for all registry events")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "secret used to sign requests to the webhook and alert-webhook URL's")
+	flag.IntVar(&pkgthreads, "pkgthreads", 5, "number of packages to process at the same time when indexing or verifying all packages")
+	flag.BoolVar(&verifyAllPkg, "verify-all", false, "verify integrity of all tarballs")
+	flag.StringVar(&verifyPkg, "verify", "", "verify integrity of tarballs for given package URI, example registry.npmjs.org/@types/react")
+	flag.BoolVar(&quarantine, "quarantine", false, "quarantine tarballs failing verification")
+	flag.BoolVar(&refetch, "refetch", false, "download tarballs failing verification again, requires the proxystash flag")
 	flag.Usage = printUsage
 }
 
@@ -71,8 +158,14 @@ func parseArgs() {
 		printUsage()
 		os.Exit(1)
 	}
-	if fetchAll && !proxystash {
-		fmt.Println("info: flag fetch-all is useless without the proxystash flag")
+	if (cooldownDays > 0 || cooldownOvr != "") && !proxystash {
+		fmt.Println("info: flag cooldown is useless without the proxystash flag")
+	}
+	if refetch && !proxystash {
+		fmt.Println("info: flag refetch is useless without the proxystash flag")
+	}
+	if watchlistFile != "" && !proxystash {
+		fmt.Println("info: flag watchlist is useless without the proxystash flag")
 	}
 	storageDir = args[0]
 }
@@ -92,6 +185,42 @@ func middleware(handler func(w http.ResponseWriter, r *http.Request) (int, error
 	}
 }
 
+// reloadOnHangup reloads the policy file when SIGHUP is received.
+func reloadOnHangup(engine *policy.Engine) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := engine.Reload(); err != nil {
+				slog.Error("error reloading policy, keeping current policy", "cause", err)
+			}
+		}
+	}()
+}
+
+// splitList splits a comma separated flag value, returning nil if it's empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// route sends requests to the mux registered for a matching path prefix, or to
+// the registry mux if there is no match. Paths under the prefixes overlap the
+// patterns for packages and tarballs and can't be registered on the same mux.
+func route(registry *http.ServeMux, prefixed map[string]*http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for prefix, mux := range prefixed {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				mux.ServeHTTP(w, r)
+				return
+			}
+		}
+		registry.ServeHTTP(w, r)
+	})
+}
+
 func main() {
 	parseArgs()
 
@@ -110,27 +239,254 @@ func main() {
 	if metadir == "" {
 		metadir = storageDir
 	}
-	store := storage.NewFileStore(storageDir, metadir)
+	fileStore := storage.NewFileStore(storageDir, metadir)
+	if cas || casMigrate {
+		fileStore = storage.NewCASFileStore(storageDir, metadir)
+	}
+	if casMigrate {
+		os.Exit(migrateCAS(fileStore, pkgthreads))
+	}
+	if casGC {
+		os.Exit(collectGarbage(fileStore))
+	}
+	if statedir == "" {
+		statedir = filepath.Join(storageDir, ".enpeeem")
+	}
+	var sqlIdx *storage.SQLIndex
+	if sqlIndex || sqlIndexRebuild {
+		if err := os.MkdirAll(statedir, 0750); err != nil {
+			slog.Error("error creating state directory, exiting", "cause", err)
+			os.Exit(1)
+		}
+		var err error
+		if sqlIdx, err = storage.OpenSQLIndex(filepath.Join(statedir, "index.db")); err != nil {
+			slog.Error("error opening sql index, exiting", "cause", err)
+			os.Exit(1)
+		}
+		fileStore = fileStore.WithSQLIndex(sqlIdx)
+		empty, err := sqlIdx.Empty()
+		if err != nil {
+			slog.Error("error reading sql index, exiting", "cause", err)
+			os.Exit(1)
+		}
+		if empty || sqlIndexRebuild {
+			slog.Info("building sql index")
+			if err := fileStore.RebuildSQLIndex(); err != nil {
+				slog.Error("error building sql index, exiting", "cause", err)
+				os.Exit(1)
+			}
+		}
+		if sqlIndexRebuild {
+			sqlIdx.Close()
+			os.Exit(0)
+		}
+	}
+	var store storage.Store = fileStore
+	if layers != "" {
+		readOnly := []storage.Store{}
+		for _, dir := range splitList(layers) {
+			readOnly = append(readOnly, storage.NewFileStore(dir, dir))
+		}
+		store = storage.NewLayeredStore(store, readOnly...)
+	}
+	storage.Upstream = upstream.New(upstream.Options{
+		MaxAttempts:      upstreamRetries + 1,
+		MaxPerHost:       upstreamMaxConns,
+		FailureThreshold: upstreamBreaker,
+		OpenTimeout:      upstreamOpen,
+	})
 	var err error
-	cfg, err = config.New(store, registry, urltemplate, proxystash, fetchAll)
+	registries := splitList(registry)
+	if len(registries) > 1 {
+		registry = registries[0]
+		if storage.Mirrors, err = upstream.NewMirrors(storage.Upstream, registries, preferLatency); err != nil {
+			slog.Error("error parsing registry mirrors, exiting", "cause", err)
+			os.Exit(1)
+		}
+	}
+	cfg, err = config.New(store, registry, urltemplate, proxystash)
 	if err != nil {
-		slog.Error("error creating config, exiting: %w", err)
+		slog.Error("error creating config, exiting", "cause", err)
+		os.Exit(1)
+	}
+	cfg.SQLIndex = sqlIdx
+	cfg.AdminToken = adminToken
+	if snapshot != "" {
+		if cfg.Snapshot, err = handle.ParseSnapshotTime(snapshot); err != nil {
+			slog.Error("error parsing snapshot time, exiting", "cause", err)
+			os.Exit(1)
+		}
+	}
+
+	if proxystash && (cooldownDays > 0 || cooldownOvr != "") {
+		overrides, err := cooldown.ParseOverrides(cooldownOvr)
+		if err != nil {
+			slog.Error("error parsing cooldown overrides, exiting", "cause", err)
+			os.Exit(1)
+		}
+		if cfg.Cooldown, err = cooldown.New(store, time.Duration(cooldownDays)*24*time.Hour, overrides, filepath.Join(statedir, "cooldown.json")); err != nil {
+			slog.Error("error loading cooldown state, exiting", "cause", err)
+			os.Exit(1)
+		}
+	}
+
+	if policyFile != "" {
+		if cfg.Policy, err = policy.Load(policyFile, store); err != nil {
+			slog.Error("error loading policy, exiting", "cause", err)
+			os.Exit(1)
+		}
+		reloadOnHangup(cfg.Policy)
+	}
+
+	if cfg.Webhooks, err = webhook.New(filepath.Join(statedir, "webhooks")); err != nil {
+		slog.Error("error loading webhooks, exiting", "cause", err)
 		os.Exit(1)
 	}
+	if webhookURL != "" {
+		if err := cfg.Webhooks.AddStatic("webhook", webhookURL, webhookSecret); err != nil {
+			slog.Error("error adding webhook, exiting", "cause", err)
+			os.Exit(1)
+		}
+	}
+	if alertWebhook != "" {
+		if err := cfg.Webhooks.AddStatic("alert-webhook", alertWebhook, webhookSecret, webhook.EventTampering); err != nil {
+			slog.Error("error adding alert webhook, exiting", "cause", err)
+			os.Exit(1)
+		}
+	}
 
+	if cfg.Changes, err = changes.Open(filepath.Join(statedir, "changes.jsonl"), store); err != nil {
+		slog.Error("error opening changes log, exiting", "cause", err)
+		os.Exit(1)
+	}
+	cfg.Webhooks.Subscribe(cfg.Changes.Record)
+	// indexing from the command line also goes through the webhook store, events
+	// are queued and delivered the next time enpeeem runs as a server
+	cfg.Store = webhook.NewStore(store, cfg.Webhooks)
+	cfg.Reindex = reindex.New(cfg.Store, pkgthreads)
 	if indexAll {
-		os.Exit(reindexAll(store, pkgthreads))
+		os.Exit(reindexAll(cfg.Store, pkgthreads))
 	}
 	if indexPkg != "" {
-		os.Exit(reindexPackage(store, indexPkg))
+		os.Exit(reindexPackage(cfg.Store, indexPkg))
+	}
+	verifyOpts := storage.VerifyOptions{Upstream: proxystash, Quarantine: quarantine, Refetch: refetch && proxystash}
+	if verifyAllPkg {
+		os.Exit(verifyAll(cfg.Store, pkgthreads, verifyOpts))
+	}
+	if verifyPkg != "" {
+		os.Exit(verifyPackageURI(cfg.Store, verifyPkg, verifyOpts))
+	}
+	cfg.Verify = verify.New(cfg.Store, pkgthreads)
+	cfg.Webhooks.Start()
+
+	if fetchAll && proxystash {
+		filter := fetch.Filter{SkipPrerelease: fetchPrerelease, MaxAge: time.Duration(fetchMaxAge) * 24 * time.Hour}
+		download := func(tarball storage.Tarball) error {
+			return handle.FetchTarball(cfg, tarball)
+		}
+		if cfg.Fetch, err = fetch.New(cfg.Store, download, fetchWorkers, filter, filepath.Join(statedir, "fetch.json")); err != nil {
+			slog.Error("error loading fetch queue, exiting", "cause", err)
+			os.Exit(1)
+		}
+		cfg.Fetch.Start()
+	}
+
+	if replicateFrom != "" {
+		filter := replicate.Filter{Registries: splitList(replicateRegistries), Scopes: splitList(replicateScopes), Packages: splitList(replicatePackages)}
+		replicator, err := replicate.New(replicateFrom, replicateToken, cfg.Store, filter, filepath.Join(statedir, "replicate.json"))
+		if err != nil {
+			slog.Error("error creating replicator, exiting", "cause", err)
+			os.Exit(1)
+		}
+		if replicateOnce {
+			if err := replicator.Run(context.Background(), true); err != nil {
+				slog.Error("replication failed", "cause", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		go replicator.Run(context.Background(), false)
+	}
+
+	if proxystash {
+		if cfg.Watchlist, err = watch.Load(watchlistFile, filepath.Join(statedir, "watchlist.json")); err != nil {
+			slog.Error("error loading watchlist, exiting", "cause", err)
+			os.Exit(1)
+		}
+		go cfg.Watchlist.Run(context.Background(), watchInterval, func(name string, keep func(version, latest string) bool) (int, error) {
+			return handle.SyncWatched(cfg, name, keep)
+		})
 	}
 
 	http.HandleFunc("GET /{pkg}", middleware(handle.PackageMetadata))
 	http.HandleFunc("GET /{pkg}/-/{tarball}", middleware(handle.Tarball))
 	http.HandleFunc("GET /{scope}/{pkg}/-/{tarball}", middleware(handle.Tarball))
-	http.HandleFunc("POST /api/index/{registry}/{pkg}", middleware(handle.Index))
+	http.HandleFunc("PUT /{pkg}", middleware(handle.Admin(handle.UpdatePackage)))
+	http.HandleFunc("PUT /{pkg}/-rev/{rev}", middleware(handle.Admin(handle.UpdatePackage)))
+	http.HandleFunc("DELETE /{pkg}/-rev/{rev}", middleware(handle.Admin(handle.Unpublish)))
+	http.HandleFunc("DELETE /{pkg}/-/{tarball}/-rev/{rev}", middleware(handle.Admin(handle.Unpublish)))
+	http.HandleFunc("DELETE /{scope}/{pkg}/-/{tarball}/-rev/{rev}", middleware(handle.Admin(handle.Unpublish)))
+	http.HandleFunc("GET /_changes", middleware(handle.Changes))
+
+	api := http.NewServeMux()
+	api.HandleFunc("POST /api/index/{registry}/{pkg}", middleware(handle.Index))
+	api.HandleFunc("GET /api/cooldown", middleware(handle.Cooldown))
+	api.HandleFunc("POST /api/cooldown/{registry}/{pkg}/{version}", middleware(handle.Admin(handle.ReleaseCooldown)))
+	api.HandleFunc("GET /api/installscripts", middleware(handle.InstallScripts))
+	api.HandleFunc("GET /api/tampering", middleware(handle.Tampering))
+	api.HandleFunc("POST /api/policy/reload", middleware(handle.Admin(handle.ReloadPolicy)))
+	api.HandleFunc("POST /api/verify", middleware(handle.Admin(handle.Verify)))
+	api.HandleFunc("POST /api/verify/{registry}/{pkg}", middleware(handle.Admin(handle.Verify)))
+	api.HandleFunc("GET /api/verify/jobs/{id}", middleware(handle.VerifyJob))
+	api.HandleFunc("GET /api/upstream", middleware(handle.Upstream))
+	api.HandleFunc("POST /api/upstream/{host}/reset", middleware(handle.Admin(handle.ResetUpstream)))
+	api.HandleFunc("GET /api/mirrors", middleware(handle.Mirrors))
+	api.HandleFunc("GET /api/stats", middleware(handle.Stats))
+	api.HandleFunc("GET /api/admin/registries", middleware(handle.Admin(handle.AdminRegistries)))
+	api.HandleFunc("GET /api/admin/packages", middleware(handle.Admin(handle.AdminPackages)))
+	api.HandleFunc("GET /api/admin/packages/{registry}/{pkg}", middleware(handle.Admin(handle.AdminVersions)))
+	api.HandleFunc("DELETE /api/admin/packages/{registry}/{pkg}", middleware(handle.Admin(handle.AdminDeletePackage)))
+	api.HandleFunc("DELETE /api/admin/packages/{registry}/{pkg}/{version}", middleware(handle.Admin(handle.AdminDeleteVersion)))
+	api.HandleFunc("GET /api/admin/index", middleware(handle.Admin(handle.AdminIndex)))
+	api.HandleFunc("POST /api/admin/index", middleware(handle.Admin(handle.AdminStartIndex)))
+	api.HandleFunc("GET /api/fetch", middleware(handle.FetchJobs))
+	api.HandleFunc("GET /api/fetch/{registry}/{pkg}", middleware(handle.FetchJob))
+	api.HandleFunc("GET /api/watchlist", middleware(handle.Watchlist))
+	api.HandleFunc("POST /api/watchlist/sync", middleware(handle.Admin(handle.SyncWatchlist)))
+	api.HandleFunc("PUT /api/watchlist/{pkg}", middleware(handle.Admin(handle.WatchPackage)))
+	api.HandleFunc("DELETE /api/watchlist/{pkg}", middleware(handle.Admin(handle.UnwatchPackage)))
+	api.HandleFunc("GET /api/replicate/{registry}/{pkg}", middleware(handle.Admin(handle.ReplicationPackage)))
+	api.HandleFunc("GET /api/tarball/{registry}/{pkg}/{tarball}", middleware(handle.Admin(handle.RawTarball)))
+	// a package can be named api
+	api.Handle("/", http.DefaultServeMux)
+
+	npm := http.NewServeMux()
+	npm.HandleFunc("GET /-/package/{pkg}/dist-tags", middleware(handle.DistTags))
+	npm.HandleFunc("GET /-/v1/search", middleware(handle.Search))
+	npm.HandleFunc("GET /-/files/{path...}", middleware(handle.Files))
+	npm.HandleFunc("GET /-/diff/{path...}", middleware(handle.Diff))
+	npm.HandleFunc("GET /-/web/{$}", middleware(web.Dashboard))
+	npm.HandleFunc("GET /-/web/packages", middleware(web.Packages))
+	npm.HandleFunc("GET /-/web/package/{registry}/{pkg}", middleware(web.Package))
+	npm.HandleFunc("GET /-/web/package/{registry}/{scope}/{pkg}", middleware(web.Package))
+	npm.Handle("GET /-/web/static/", web.Static())
+	npm.HandleFunc("GET /-/package/{pkg}/dist-tags/{tag}", middleware(handle.DistTags))
+	npm.HandleFunc("PUT /-/package/{pkg}/dist-tags/{tag}", middleware(handle.Admin(handle.PutDistTag)))
+	npm.HandleFunc("DELETE /-/package/{pkg}/dist-tags/{tag}", middleware(handle.Admin(handle.DeleteDistTag)))
+	npm.HandleFunc("GET /-/npm/v1/hooks", middleware(handle.Hooks))
+	npm.HandleFunc("POST /-/npm/v1/hooks/hook", middleware(handle.Admin(handle.AddHook)))
+	npm.HandleFunc("GET /-/npm/v1/hooks/hook/{id}", middleware(handle.Hook))
+	npm.HandleFunc("PUT /-/npm/v1/hooks/hook/{id}", middleware(handle.Admin(handle.UpdateHook)))
+	npm.HandleFunc("DELETE /-/npm/v1/hooks/hook/{id}", middleware(handle.Admin(handle.DeleteHook)))
+
+	snapshots := http.NewServeMux()
+	snapshots.HandleFunc("GET /@snapshot/{date}/{pkg}", middleware(handle.PackageMetadata))
+	snapshots.HandleFunc("GET /@snapshot/{date}/{pkg}/-/{tarball}", middleware(handle.Tarball))
+	snapshots.HandleFunc("GET /@snapshot/{date}/{scope}/{pkg}/-/{tarball}", middleware(handle.Tarball))
+
 	slog.Info("started enpeeem", "addr", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := http.ListenAndServe(addr, route(http.DefaultServeMux, map[string]*http.ServeMux{"/api/": api, "/-/": npm, "/@snapshot/": snapshots})); err != nil {
 		slog.Error("server error", "cause", err)
 	}
 }
@@ -0,0 +1,329 @@
+package policy
+
+import (
+	"encoding/json"
+	"enpeeem/storage"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule matches packages and versions, a rule matches if all of it's conditions
+// match. Conditions left empty always match.
+type Rule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// Packages are globs matched against the package name including scope,
+	// for example @types/* or left-pad.
+	Packages []string `json:"packages,omitempty"`
+	// Versions is a semver constraint, for example >=2.0.0 <3.0.0.
+	Versions string `json:"versions,omitempty"`
+	// Licenses matches versions with a license expression that can't be
+	// satisfied using only these SPDX identifiers.
+	Licenses []string `json:"licenses,omitempty"`
+	// InstallScripts matches versions with or without install scripts.
+	InstallScripts *bool `json:"installScripts,omitempty"`
+	// MaxSize matches tarballs larger than the given number of bytes.
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	constraint *semver.Constraints
+}
+
+// InstallScripts denies versions with install scripts unless the package is
+// approved. It's checked before any rules.
+type InstallScripts struct {
+	Deny bool `json:"deny"`
+	// Approved are globs matched against the package name including scope.
+	Approved []string `json:"approved,omitempty"`
+}
+
+// Policy is a list of rules evaluated in order, the first matching rule decides
+// if a package or version is allowed. Deny rules without version conditions take
+// precedence, they deny the package even if an earlier rule allows some of it's
+// versions. The default action is used if no rule matches.
+type Policy struct {
+	Default        string         `json:"default"`
+	InstallScripts InstallScripts `json:"installScripts"`
+	Typosquat      Typosquat      `json:"typosquat"`
+	Rules          []Rule         `json:"rules"`
+}
+
+// Denial is returned when a package or version is denied by a rule.
+type Denial struct {
+	Rule    string
+	Package string
+	Version string
+	Reason  string
+}
+
+func (d *Denial) Error() string {
+	name := d.Package
+	if d.Version != "" {
+		name += "@" + d.Version
+	}
+	if d.Reason != "" {
+		return fmt.Sprintf("%s is denied by policy rule %q: %s", name, d.Rule, d.Reason)
+	}
+	return fmt.Sprintf("%s is denied by policy rule %q", name, d.Rule)
+}
+
+// Engine evaluates packages and versions against a policy file. The policy file
+// can be reloaded while the engine is in use.
+type Engine struct {
+	file   string
+	store  storage.Store
+	mux    sync.RWMutex
+	policy Policy
+
+	namesMux    sync.Mutex
+	names       *knownNames
+	namesLoaded time.Time
+}
+
+// Load reads the policy file and returns an engine using it. The store is used
+// to find internal and known package names, it may be nil.
+func Load(file string, store storage.Store) (*Engine, error) {
+	engine := &Engine{file: file, store: store}
+	return engine, engine.Reload()
+}
+
+// Reload reads the policy file again. The current policy is kept if the file
+// can't be read or is invalid.
+func (e *Engine) Reload() error {
+	data, err := os.ReadFile(e.file)
+	if err != nil {
+		return err
+	}
+	p := Policy{Default: Allow}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("error parsing policy file %s: %w", e.file, err)
+	}
+	if err := p.compile(); err != nil {
+		return fmt.Errorf("error in policy file %s: %w", e.file, err)
+	}
+	e.namesMux.Lock()
+	e.names = nil
+	e.namesMux.Unlock()
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.policy = p
+	slog.Info("policy loaded", "file", e.file, "rules", len(p.Rules))
+	return nil
+}
+
+func (p *Policy) compile() error {
+	if p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("default action must be %s or %s", Allow, Deny)
+	}
+	if err := validGlobs(p.InstallScripts.Approved); err != nil {
+		return fmt.Errorf("install scripts: %w", err)
+	}
+	if p.Typosquat.Action != "" && p.Typosquat.Action != Deny && p.Typosquat.Action != Warn {
+		return fmt.Errorf("typosquat: action must be %s or %s", Deny, Warn)
+	}
+	if p.Typosquat.SimilarAction != "" && p.Typosquat.SimilarAction != Deny && p.Typosquat.SimilarAction != Warn {
+		return fmt.Errorf("typosquat: similarAction must be %s or %s", Deny, Warn)
+	}
+	if err := validGlobs(p.Typosquat.InternalPackages); err != nil {
+		return fmt.Errorf("typosquat: %w", err)
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if rule.Action != Allow && rule.Action != Deny {
+			return fmt.Errorf("rule %s: action must be %s or %s", rule.Name, Allow, Deny)
+		}
+		if err := validGlobs(rule.Packages); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if rule.Versions != "" {
+			c, err := semver.NewConstraint(rule.Versions)
+			if err != nil {
+				return fmt.Errorf("rule %s: invalid versions %s: %w", rule.Name, rule.Versions, err)
+			}
+			p.Rules[i].constraint = c
+		}
+	}
+	return nil
+}
+
+// CheckPackage returns a Denial if the package is denied regardless of version. All
+// matching rules are evaluated and deny rules take precedence, rules with version
+// conditions matching the package are left to CheckVersion.
+func (e *Engine) CheckPackage(pkg storage.Package) error {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	if rule, found := e.packageDenied(pkg); found {
+		return e.decide(Deny, rule.Name, pkg, "")
+	}
+	for _, rule := range e.policy.Rules {
+		if rule.matchPackage(pkg) {
+			return nil
+		}
+	}
+	return e.decide(e.policy.Default, "default", pkg, "")
+}
+
+// CheckVersion returns a Denial if the version is denied. Meta is the version
+// metadata, or package.json, and size is the tarball size in bytes, or -1 if not
+// known. Rules with a max size never match if the size is not known.
+func (e *Engine) CheckVersion(pkg storage.Package, version string, meta map[string]interface{}, size int64) error {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	if e.policy.InstallScripts.Deny && storage.HasInstallScript(meta) && !matchGlobs(e.policy.InstallScripts.Approved, pkg) {
+		return e.decide(Deny, "installScripts", pkg, version)
+	}
+	if rule, found := e.packageDenied(pkg); found {
+		return e.decide(Deny, rule.Name, pkg, version)
+	}
+	for _, rule := range e.policy.Rules {
+		if rule.matchPackage(pkg) && rule.matchVersion(version, meta, size) {
+			return e.decide(rule.Action, rule.Name, pkg, version)
+		}
+	}
+	return e.decide(e.policy.Default, "default", pkg, version)
+}
+
+// packageDenied returns the first deny rule without version conditions matching
+// the package.
+func (e *Engine) packageDenied(pkg storage.Package) (Rule, bool) {
+	for _, rule := range e.policy.Rules {
+		if rule.Action == Deny && !rule.versionConditions() && rule.matchPackage(pkg) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (e *Engine) decide(action, rule string, pkg storage.Package, version string) error {
+	if action == Allow {
+		return nil
+	}
+	slog.Debug("denied by policy", "rule", rule, "pkg", pkg.String(), "version", version)
+	return &Denial{Rule: rule, Package: pkg.FullName(), Version: version}
+}
+
+func (rule Rule) versionConditions() bool {
+	return rule.Versions != "" || len(rule.Licenses) > 0 || rule.InstallScripts != nil || rule.MaxSize > 0
+}
+
+func (rule Rule) matchPackage(pkg storage.Package) bool {
+	if len(rule.Packages) == 0 {
+		return true
+	}
+	return matchGlobs(rule.Packages, pkg)
+}
+
+func matchGlobs(globs []string, pkg storage.Package) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, pkg.FullName()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid package glob %s: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+func (rule Rule) matchVersion(version string, meta map[string]interface{}, size int64) bool {
+	if rule.constraint != nil && !matchConstraint(rule.constraint, version) {
+		return false
+	}
+	if len(rule.Licenses) > 0 {
+		satisfied, err := licenseSatisfied(License(meta), rule.Licenses)
+		if err == nil && satisfied {
+			return false
+		}
+	}
+	if rule.InstallScripts != nil && storage.HasInstallScript(meta) != *rule.InstallScripts {
+		return false
+	}
+	if rule.MaxSize > 0 && (size < 0 || size <= rule.MaxSize) {
+		return false
+	}
+	return true
+}
+
+// matchConstraint checks version against constraint including prereleases. The
+// semver library never matches prereleases against constraints without one, so
+// prereleases are also checked as the version they precede, <2.0.0 matches
+// 1.9.0-beta.1.
+func matchConstraint(constraint *semver.Constraints, version string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	if constraint.Check(v) {
+		return true
+	}
+	if v.Prerelease() == "" {
+		return false
+	}
+	release, err := v.SetPrerelease("")
+	return err == nil && constraint.Check(&release)
+}
+
+// License returns the license expression from version metadata. The deprecated
+// object and list formats are converted to an expression.
+func License(meta map[string]interface{}) string {
+	switch license := meta["license"].(type) {
+	case string:
+		return license
+	case map[string]interface{}:
+		t, _ := license["type"].(string)
+		return t
+	}
+	licenses, _ := meta["licenses"].([]interface{})
+	expression := ""
+	for _, l := range licenses {
+		license, _ := l.(map[string]interface{})
+		if t, _ := license["type"].(string); t != "" {
+			if expression != "" {
+				expression += " OR "
+			}
+			expression += t
+		}
+	}
+	return expression
+}
+
+// Filter removes versions denied by the policy from raw package metadata.
+func (e *Engine) Filter(pkg storage.Package, data []byte) ([]byte, error) {
+	doc := struct {
+		Versions map[string]map[string]interface{} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, err
+	}
+	denied := map[string]bool{}
+	for v, meta := range doc.Versions {
+		if e.CheckVersion(pkg, v, meta, -1) != nil {
+			denied[v] = true
+		}
+	}
+	if len(denied) == 0 {
+		return data, nil
+	}
+	slog.Debug("versions denied by policy removed from package metadata", "pkg", pkg.String(), "versions", len(denied))
+	return storage.FilterVersions(data, func(version string, _ time.Time, _ bool) bool {
+		return !denied[version]
+	})
+}
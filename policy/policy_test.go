@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"enpeeem/storage"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLicenseSatisfied(t *testing.T) {
+	allowed := []string{"MIT", "Apache-2.0", "BSD-3-Clause"}
+	type Test struct {
+		Expression string
+		Expected   bool
+	}
+	tests := []Test{
+		{Expression: "MIT", Expected: true},
+		{Expression: "mit", Expected: true},
+		{Expression: "GPL-3.0", Expected: false},
+		{Expression: "", Expected: false},
+		{Expression: "MIT OR GPL-3.0", Expected: true},
+		{Expression: "MIT AND GPL-3.0", Expected: false},
+		{Expression: "(MIT OR GPL-3.0) AND Apache-2.0", Expected: true},
+		{Expression: "(GPL-2.0 OR GPL-3.0) AND Apache-2.0", Expected: false},
+		{Expression: "Apache-2.0 WITH LLVM-exception", Expected: true},
+	}
+	for _, test := range tests {
+		actual, err := licenseSatisfied(test.Expression, allowed)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Expression, err)
+		}
+		if actual != test.Expected {
+			t.Errorf("%s: expected %v but got %v", test.Expression, test.Expected, actual)
+		}
+	}
+	if _, err := licenseSatisfied("(MIT OR", allowed); err == nil {
+		t.Error("expected error for invalid expression")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(file, []byte(`{
+		"default": "allow",
+		"rules": [
+			{"name": "internal", "action": "allow", "packages": ["@mycompany/*"]},
+			{"name": "no-left-pad", "action": "deny", "packages": ["left-pad"]},
+			{"name": "old-lodash", "action": "deny", "packages": ["lodash"], "versions": "<4.17.21"},
+			{"name": "licenses", "action": "deny", "licenses": ["MIT", "ISC"]},
+			{"name": "scripts", "action": "deny", "installScripts": true},
+			{"name": "size", "action": "deny", "maxSize": 1000}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := Load(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := func(scope, name string) storage.Package {
+		return storage.Package{Registry: "registry.npmjs.org", Scope: scope, Name: name}
+	}
+	mit := map[string]interface{}{"license": "MIT"}
+
+	if err := engine.CheckPackage(pkg("", "left-pad")); err == nil {
+		t.Error("expected left-pad to be denied")
+	}
+	if err := engine.CheckPackage(pkg("", "lodash")); err != nil {
+		t.Errorf("expected lodash package to be allowed but got %v", err)
+	}
+
+	type Test struct {
+		Package storage.Package
+		Version string
+		Meta    map[string]interface{}
+		Size    int64
+		Rule    string
+	}
+	tests := []Test{
+		{Package: pkg("@mycompany", "ui"), Version: "1.0.0", Meta: map[string]interface{}{}, Size: 5000, Rule: ""},
+		{Package: pkg("", "lodash"), Version: "4.17.20", Meta: mit, Size: -1, Rule: "old-lodash"},
+		{Package: pkg("", "lodash"), Version: "4.17.21", Meta: mit, Size: -1, Rule: ""},
+		{Package: pkg("", "lodash"), Version: "4.17.20-beta.1", Meta: mit, Size: -1, Rule: "old-lodash"},
+		{Package: pkg("", "lodash"), Version: "4.17.22-beta.1", Meta: mit, Size: -1, Rule: ""},
+		{Package: pkg("", "react"), Version: "18.0.0", Meta: map[string]interface{}{"license": "GPL-3.0"}, Size: -1, Rule: "licenses"},
+		{Package: pkg("", "esbuild"), Version: "1.0.0", Meta: map[string]interface{}{"license": "MIT", "scripts": map[string]interface{}{"postinstall": "node install.js"}}, Size: -1, Rule: "scripts"},
+		{Package: pkg("", "react"), Version: "18.0.0", Meta: mit, Size: 2000, Rule: "size"},
+		{Package: pkg("", "react"), Version: "18.0.0", Meta: mit, Size: 500, Rule: ""},
+	}
+	for _, test := range tests {
+		err := engine.CheckVersion(test.Package, test.Version, test.Meta, test.Size)
+		var denial *Denial
+		if test.Rule == "" && err != nil {
+			t.Errorf("%s@%s: expected to be allowed but got %v", test.Package.FullName(), test.Version, err)
+		}
+		if test.Rule != "" && (!errors.As(err, &denial) || denial.Rule != test.Rule) {
+			t.Errorf("%s@%s: expected to be denied by %s but got %v", test.Package.FullName(), test.Version, test.Rule, err)
+		}
+	}
+}
+
+func TestDenyPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(file, []byte(`{
+		"default": "allow",
+		"rules": [
+			{"name": "new-lodash", "action": "allow", "packages": ["lodash"], "versions": ">=4.17.21"},
+			{"name": "no-lodash", "action": "deny", "packages": ["lodash"]}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := Load(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := storage.Package{Registry: "registry.npmjs.org", Name: "lodash"}
+	var denial *Denial
+	if err := engine.CheckPackage(pkg); !errors.As(err, &denial) || denial.Rule != "no-lodash" {
+		t.Errorf("expected lodash to be denied by no-lodash but got %v", err)
+	}
+	if err := engine.CheckVersion(pkg, "4.17.21", map[string]interface{}{}, -1); !errors.As(err, &denial) || denial.Rule != "no-lodash" {
+		t.Errorf("expected lodash@4.17.21 to be denied by no-lodash but got %v", err)
+	}
+}
+
+func TestInstallScripts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(file, []byte(`{
+		"installScripts": {"deny": true, "approved": ["esbuild", "@swc/*"]},
+		"rules": [{"name": "allow-all", "action": "allow"}]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := Load(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]interface{}{"hasInstallScript": true}
+
+	type Test struct {
+		Package storage.Package
+		Meta    map[string]interface{}
+		Denied  bool
+	}
+	tests := []Test{
+		{Package: storage.Package{Registry: "registry.npmjs.org", Name: "esbuild"}, Meta: scripts, Denied: false},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Scope: "@swc", Name: "core"}, Meta: scripts, Denied: false},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Name: "malware"}, Meta: scripts, Denied: true},
+		{Package: storage.Package{Registry: "registry.npmjs.org", Name: "react"}, Meta: map[string]interface{}{}, Denied: false},
+	}
+	for _, test := range tests {
+		err := engine.CheckVersion(test.Package, "1.0.0", test.Meta, -1)
+		if (err != nil) != test.Denied {
+			t.Errorf("%s: expected denied %v but got %v", test.Package.FullName(), test.Denied, err)
+		}
+	}
+}
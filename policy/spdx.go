@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// licenseSatisfied returns true if the SPDX license expression can be satisfied
+// using only the allowed licenses. Licenses are compared case insensitive and
+// license exceptions (WITH) are ignored.
+func licenseSatisfied(expression string, allowed []string) (bool, error) {
+	p := spdxParser{tokens: tokenize(expression), allowed: allowed}
+	if len(p.tokens) == 0 {
+		return false, nil
+	}
+	ok, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected %s in license expression %s", p.tokens[p.pos], expression)
+	}
+	return ok, nil
+}
+
+func tokenize(expression string) []string {
+	expression = strings.ReplaceAll(expression, "(", " ( ")
+	expression = strings.ReplaceAll(expression, ")", " ) ")
+	return strings.Fields(expression)
+}
+
+type spdxParser struct {
+	tokens  []string
+	pos     int
+	allowed []string
+}
+
+func (p *spdxParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *spdxParser) or() (bool, error) {
+	ok, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		ok = ok || right
+	}
+	return ok, nil
+}
+
+func (p *spdxParser) and() (bool, error) {
+	ok, err := p.license()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.pos++
+		right, err := p.license()
+		if err != nil {
+			return false, err
+		}
+		ok = ok && right
+	}
+	return ok, nil
+}
+
+func (p *spdxParser) license() (bool, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "":
+		return false, fmt.Errorf("unexpected end of license expression")
+	case token == "(":
+		ok, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if p.peek() != ")" {
+			return false, fmt.Errorf("missing ) in license expression")
+		}
+		p.pos++
+		return ok, nil
+	case token == ")" || strings.EqualFold(token, "AND") || strings.EqualFold(token, "OR") || strings.EqualFold(token, "WITH"):
+		return false, fmt.Errorf("unexpected %s in license expression", token)
+	}
+	if strings.EqualFold(p.peek(), "WITH") {
+		p.pos += 2
+	}
+	for _, allowed := range p.allowed {
+		if strings.EqualFold(token, allowed) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,234 @@
+package policy
+
+import (
+	"enpeeem/storage"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const Warn = "warn"
+
+// nameCacheTTL is how long the list of stashed package names is cached.
+const nameCacheTTL = time.Minute
+
+// Typosquat checks package names fetched from the remote registry for the first
+// time against internal and known package names.
+type Typosquat struct {
+	// Action is deny to block suspicious names or warn to log them, empty
+	// disables the check.
+	Action string `json:"action"`
+	// SimilarAction is used instead of Action for names only a small edit distance
+	// from a known name, like reakt and react. Set it to warn to only log them,
+	// since legitimate packages like preact and react are also similar.
+	SimilarAction string `json:"similarAction,omitempty"`
+	// InternalScopes are never fetched from the remote registry.
+	InternalScopes []string `json:"internalScopes,omitempty"`
+	// InternalPackages are globs for unscoped internal package names, never
+	// fetched from the remote registry.
+	InternalPackages []string `json:"internalPackages,omitempty"`
+	// Popular are package names to compare with in addition to stashed packages.
+	Popular []string `json:"popular,omitempty"`
+}
+
+// homoglyphs are replaced to find names looking the same as a known name.
+var homoglyphs = strings.NewReplacer("rn", "m", "vv", "w", "0", "o", "1", "l", "i", "l", "3", "e", "5", "s", "-", "", "_", "", ".", "")
+
+// Internal returns true if the package is internal and must never be fetched
+// from the remote registry. Packages are internal if they are in an internal
+// scope, matches an internal package name or if any version has been published
+// directly to enpeeem.
+func (e *Engine) Internal(pkg storage.Package) (bool, error) {
+	e.mux.RLock()
+	t := e.policy.Typosquat
+	e.mux.RUnlock()
+	if pkg.Scope != "" && slices.Contains(t.InternalScopes, pkg.Scope) {
+		return true, nil
+	}
+	if pkg.Scope == "" && matchGlobs(t.InternalPackages, pkg) {
+		return true, nil
+	}
+	if e.store == nil {
+		return false, nil
+	}
+	published, err := storage.GetPublished(e.store, pkg)
+	return len(published) > 0, err
+}
+
+// CheckTyposquat returns a Denial if the package is not stashed and it's name is
+// suspiciously similar to a known package name or internal scope. If the action is
+// warn suspicious names are only logged.
+func (e *Engine) CheckTyposquat(pkg storage.Package) error {
+	e.mux.RLock()
+	t := e.policy.Typosquat
+	e.mux.RUnlock()
+	if t.Action == "" || e.store == nil {
+		return nil
+	}
+	if internal, err := e.Internal(pkg); err != nil || internal {
+		return err
+	}
+	tarballs, err := e.store.Tarballs(pkg)
+	if err != nil || len(tarballs) > 0 {
+		return err
+	}
+	known, err := e.knownNames(t.Popular)
+	if err != nil {
+		return err
+	}
+	reason, similar := suspicious(pkg, known, t.InternalScopes)
+	if reason == "" {
+		return nil
+	}
+	action := t.Action
+	if similar && t.SimilarAction != "" {
+		action = t.SimilarAction
+	}
+	if action == Warn {
+		slog.Warn("suspicious package name", "pkg", pkg.String(), "reason", reason)
+		return nil
+	}
+	return &Denial{Rule: "typosquat", Package: pkg.FullName(), Reason: reason}
+}
+
+// knownNames are package names indexed for comparing them with new names,
+// without comparing every name on each request.
+type knownNames struct {
+	names map[string]bool
+	// lookalikes are known names by their name with homoglyphs replaced
+	lookalikes map[string][]string
+	// scoped are known scoped names by their name without scope
+	scoped map[string][]string
+	// lengths are known names by their length
+	lengths map[int][]string
+}
+
+func newKnownNames(names []string) *knownNames {
+	known := &knownNames{names: map[string]bool{}, lookalikes: map[string][]string{}, scoped: map[string][]string{}, lengths: map[int][]string{}}
+	for _, name := range names {
+		if known.names[name] {
+			continue
+		}
+		known.names[name] = true
+		normalized := homoglyphs.Replace(strings.ToLower(name))
+		known.lookalikes[normalized] = append(known.lookalikes[normalized], name)
+		if _, n, found := strings.Cut(name, "/"); found {
+			known.scoped[n] = append(known.scoped[n], name)
+		}
+		length := utf8.RuneCountInString(name)
+		known.lengths[length] = append(known.lengths[length], name)
+	}
+	return known
+}
+
+// knownNames returns the names of all stashed packages and the popular names.
+// Stashed names are cached for nameCacheTTL, the cache is cleared when the
+// policy is reloaded.
+func (e *Engine) knownNames(popular []string) (*knownNames, error) {
+	e.namesMux.Lock()
+	defer e.namesMux.Unlock()
+	if e.names != nil && time.Since(e.namesLoaded) < nameCacheTTL {
+		return e.names, nil
+	}
+	pkgs, err := e.store.Packages()
+	if err != nil {
+		return nil, err
+	}
+	names := slices.Clone(popular)
+	for _, pkg := range pkgs {
+		names = append(names, pkg.FullName())
+	}
+	e.names = newKnownNames(names)
+	e.namesLoaded = time.Now()
+	return e.names, nil
+}
+
+// suspicious returns a reason if the package name looks like an attempt to
+// impersonate a known package or an internal scope. similar is true if the name
+// is only a small edit distance from a known name, which is common for legitimate
+// packages like preact and react or eslint and tslint.
+func suspicious(pkg storage.Package, known *knownNames, internalScopes []string) (reason string, similar bool) {
+	if pkg.Scope != "" {
+		for _, scope := range internalScopes {
+			if lookalike(pkg.Scope, scope) || nearby(pkg.Scope, scope) {
+				return fmt.Sprintf("scope %s is similar to internal scope %s", pkg.Scope, scope), false
+			}
+		}
+	} else {
+		for _, name := range known.scoped[pkg.Name] {
+			scope, _, _ := strings.Cut(name, "/")
+			if slices.Contains(internalScopes, scope) {
+				return fmt.Sprintf("same name as internal package %s", name), false
+			}
+		}
+	}
+	name := pkg.FullName()
+	if known.names[name] {
+		return "", false
+	}
+	for _, k := range known.lookalikes[homoglyphs.Replace(strings.ToLower(name))] {
+		if lookalike(name, k) {
+			return fmt.Sprintf("name looks like %s", k), false
+		}
+	}
+	// names more than two characters longer or shorter are never nearby
+	length := utf8.RuneCountInString(name)
+	for l := length - 2; l <= length+2; l++ {
+		for _, k := range known.lengths[l] {
+			if nearby(name, k) {
+				return fmt.Sprintf("name is similar to %s", k), true
+			}
+		}
+	}
+	return "", false
+}
+
+// lookalike returns true if a and b are different but look the same after
+// replacing homoglyphs.
+func lookalike(a, b string) bool {
+	return a != b && homoglyphs.Replace(strings.ToLower(a)) == homoglyphs.Replace(strings.ToLower(b))
+}
+
+// nearby returns true if a and b are different but within a small edit distance of
+// each other. Short names are never close since they are too likely to be close
+// to each other.
+func nearby(a, b string) bool {
+	maxDistance := 0
+	switch {
+	case len(b) > 10:
+		maxDistance = 2
+	case len(b) >= 5:
+		maxDistance = 1
+	}
+	return a != b && maxDistance > 0 && distance(a, b) <= maxDistance
+}
+
+// distance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to change a into b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"enpeeem/storage"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSuspicious(t *testing.T) {
+	known := newKnownNames([]string{"react", "lodash", "express", "@mycompany/utils", "typescript", "vue"})
+	internalScopes := []string{"@mycompany"}
+	type Test struct {
+		Scope      string
+		Name       string
+		Suspicious bool
+		Similar    bool
+	}
+	tests := []Test{
+		{Name: "react", Suspicious: false},
+		{Name: "reakt", Suspicious: true, Similar: true},
+		{Name: "preact", Suspicious: true, Similar: true},
+		{Name: "1odash", Suspicious: true},
+		{Name: "lodahs", Suspicious: true, Similar: true},
+		{Name: "expres", Suspicious: true, Similar: true},
+		{Name: "typescirpt", Suspicious: true, Similar: true},
+		{Name: "type-script", Suspicious: true},
+		{Name: "vue", Suspicious: false},
+		{Name: "vuu", Suspicious: false},
+		{Name: "utils", Suspicious: true},
+		{Name: "left-pad", Suspicious: false},
+		{Scope: "@mycompamy", Name: "utils", Suspicious: true},
+		{Scope: "@my-company", Name: "anything", Suspicious: true},
+		{Scope: "@other", Name: "utils", Suspicious: false},
+	}
+	for _, test := range tests {
+		pkg := storage.Package{Registry: "registry.npmjs.org", Scope: test.Scope, Name: test.Name}
+		reason, similar := suspicious(pkg, known, internalScopes)
+		if (reason != "") != test.Suspicious {
+			t.Errorf("%s: expected suspicious %v but got %q", pkg.FullName(), test.Suspicious, reason)
+		}
+		if similar != test.Similar {
+			t.Errorf("%s: expected similar %v but got %v", pkg.FullName(), test.Similar, similar)
+		}
+	}
+}
+
+func TestCheckTyposquat(t *testing.T) {
+	store := storage.NewFileStore(t.TempDir(), t.TempDir())
+	file := filepath.Join(t.TempDir(), "policy.json")
+	type Test struct {
+		Typosquat string
+		Name      string
+		Denied    bool
+	}
+	tests := []Test{
+		{Typosquat: `{"action": "deny", "popular": ["react"]}`, Name: "reakt", Denied: true},
+		{Typosquat: `{"action": "deny", "similarAction": "warn", "popular": ["react"]}`, Name: "reakt", Denied: false},
+		{Typosquat: `{"action": "deny", "similarAction": "warn", "popular": ["lodash"]}`, Name: "1odash", Denied: true},
+		{Typosquat: `{"action": "warn", "similarAction": "deny", "popular": ["react"]}`, Name: "reakt", Denied: true},
+	}
+	for _, test := range tests {
+		if err := os.WriteFile(file, []byte(`{"typosquat": `+test.Typosquat+`}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		engine, err := Load(file, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := storage.Package{Registry: "registry.npmjs.org", Scope: "", Name: test.Name}
+		if err := engine.CheckTyposquat(pkg); (err != nil) != test.Denied {
+			t.Errorf("%s %s: expected denied %v but got %v", test.Typosquat, test.Name, test.Denied, err)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	type Test struct {
+		A, B     string
+		Expected int
+	}
+	tests := []Test{
+		{A: "", B: "abc", Expected: 3},
+		{A: "react", B: "react", Expected: 0},
+		{A: "react", B: "reakt", Expected: 1},
+		{A: "kitten", B: "sitting", Expected: 3},
+		{A: "typescript", B: "typescirpt", Expected: 1},
+	}
+	for _, test := range tests {
+		if actual := distance(test.A, test.B); actual != test.Expected {
+			t.Errorf("%s %s: expected %d but got %d", test.A, test.B, test.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package reindex
+
+import (
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alitto/pond"
+)
+
+type State string
+
+const (
+	StateRunning State = "running"
+	StateDone    State = "done"
+)
+
+// maxErrors is the number of errors kept in a job, the failed count has them all.
+const maxErrors = 100
+
+var (
+	ErrRunning    = errors.New("all packages are already being indexed")
+	ErrNotStarted = errors.New("indexing all packages was not started")
+)
+
+// Job is the progress of indexing all packages.
+type Job struct {
+	State    State      `json:"state"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	Errors   []string   `json:"errors,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// All indexes the packages, threads packages at the same time. done is called
+// after each package, never at the same time.
+func All(store storage.Store, pkgs []storage.Package, threads int, done func(pkg storage.Package, err error)) {
+	mux := sync.Mutex{}
+	pool := pond.New(threads, 0)
+	for _, pkg := range pkgs {
+		pool.Submit(func() {
+			_, err := store.Index(pkg)
+			mux.Lock()
+			defer mux.Unlock()
+			done(pkg, err)
+		})
+	}
+	pool.StopAndWait()
+}
+
+// Indexer indexes all packages in the background, one job at a time.
+type Indexer struct {
+	store   storage.Store
+	threads int
+	mux     sync.Mutex
+	job     *Job
+}
+
+func New(store storage.Store, threads int) *Indexer {
+	return &Indexer{store: store, threads: threads}
+}
+
+// Start starts indexing all packages unless a job is running.
+func (ix *Indexer) Start() (Job, error) {
+	ix.mux.Lock()
+	defer ix.mux.Unlock()
+	if ix.job != nil && ix.job.State == StateRunning {
+		return *ix.job, ErrRunning
+	}
+	ix.job = &Job{State: StateRunning, Started: time.Now()}
+	go ix.run()
+	return *ix.job, nil
+}
+
+// Job returns the running or last finished job.
+func (ix *Indexer) Job() (Job, error) {
+	ix.mux.Lock()
+	defer ix.mux.Unlock()
+	if ix.job == nil {
+		return Job{}, ErrNotStarted
+	}
+	job := *ix.job
+	job.Errors = append([]string{}, ix.job.Errors...)
+	return job, nil
+}
+
+func (ix *Indexer) run() {
+	slog.Info("indexing all packages")
+	pkgs, err := storage.PackagesOnDisk(ix.store)
+	ix.mux.Lock()
+	ix.job.Total = len(pkgs)
+	ix.mux.Unlock()
+	if err == nil {
+		All(ix.store, pkgs, ix.threads, func(pkg storage.Package, err error) {
+			ix.mux.Lock()
+			defer ix.mux.Unlock()
+			ix.job.Done++
+			if err != nil {
+				slog.Error("error indexing package", "cause", err, "package", pkg.String())
+				ix.fail(fmt.Errorf("%s: %w", pkg.String(), err))
+			}
+		})
+	}
+	ix.mux.Lock()
+	defer ix.mux.Unlock()
+	if err != nil {
+		slog.Error("failed to list packages", "cause", err)
+		ix.fail(err)
+	}
+	finished := time.Now()
+	ix.job.State = StateDone
+	ix.job.Finished = &finished
+	slog.Info("indexed all packages", "packages", ix.job.Done, "failed", ix.job.Failed)
+}
+
+func (ix *Indexer) fail(err error) {
+	ix.job.Failed++
+	if len(ix.job.Errors) < maxErrors {
+		ix.job.Errors = append(ix.job.Errors, err.Error())
+	}
+}
@@ -0,0 +1,49 @@
+package reindex
+
+import (
+	"enpeeem/storage"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIndexer(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, name := range []string{"a", "b", "c"} {
+		pkg := storage.Package{Registry: "registry.npmjs.org", Name: name}
+		// b is not a valid tarball and fails indexing
+		if err := store.PutTarball(storage.NewTarball(pkg, name+"-1.0.0.tgz"), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if name == "b" {
+			if err := store.PutPackage(pkg, []byte("{")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ix := New(store, 2)
+	if _, err := ix.Job(); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("expected %v but got %v", ErrNotStarted, err)
+	}
+	if _, err := ix.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	for i := 0; i < 100; i++ {
+		var err error
+		if job, err = ix.Job(); err != nil {
+			t.Fatal(err)
+		}
+		if job.State == StateDone {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.State != StateDone || job.Total != 3 || job.Done != 3 || job.Failed != 1 || len(job.Errors) != 1 || job.Finished == nil {
+		t.Errorf("expected a finished job with 1 of 3 packages failed but got %+v", job)
+	}
+	if _, err := store.GetPackageMetadata(storage.Package{Registry: "registry.npmjs.org", Name: "a"}); err != nil {
+		t.Errorf("expected package metadata but got %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"enpeeem/changes"
+	"enpeeem/storage"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Assets are the package assets copied to replicas. Other assets, like the
+// tampering audit, are specific to an instance.
+var Assets = []string{
+	storage.DistTagsAssetName,
+	storage.DeprecationsAssetName,
+	storage.TimeAssetName,
+	storage.PublishedAssetName,
+	storage.IntegrityAssetName,
+}
+
+const (
+	batchSize    = 500
+	pollTimeout  = time.Minute
+	maxRetryWait = 5 * time.Minute
+)
+
+// Package is served by the primary for each package, Tarballs maps stashed
+// tarball names to their integrity, empty if not known.
+type Package struct {
+	Tarballs map[string]string `json:"tarballs"`
+	Assets   map[string][]byte `json:"assets"`
+}
+
+// Filter selects packages to replicate. Each non empty list must have a match, all
+// packages are replicated if all lists are empty.
+type Filter struct {
+	Registries []string
+	Scopes     []string
+	// Packages are globs matched against the package name including scope.
+	Packages []string
+}
+
+// Match returns true if the package should be replicated.
+func (f Filter) Match(pkg storage.Package) bool {
+	if len(f.Registries) > 0 && !slices.Contains(f.Registries, pkg.Registry) {
+		return false
+	}
+	if len(f.Scopes) > 0 && !slices.Contains(f.Scopes, pkg.Scope) {
+		return false
+	}
+	if len(f.Packages) == 0 {
+		return true
+	}
+	for _, glob := range f.Packages {
+		if matched, _ := path.Match(glob, pkg.FullName()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Replicator copies packages from a primary enpeeem instance by following it's
+// changes feed. The last replicated sequence number is saved in a checkpoint file
+// so replication resumes where it stopped.
+type Replicator struct {
+	from       string
+	token      string
+	store      storage.Store
+	filter     Filter
+	checkpoint string
+	client     *http.Client
+}
+
+type checkpoint struct {
+	From  string `json:"from"`
+	Since int64  `json:"since"`
+}
+
+// New returns a replicator copying packages from the primary at URL from. Token is
+// the admin token of the primary, required by it's replication endpoints.
+func New(from, token string, store storage.Store, filter Filter, checkpointFile string) (*Replicator, error) {
+	u, err := url.Parse(from)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid primary URL %s", from)
+	}
+	return &Replicator{
+		from:       strings.TrimSuffix(from, "/"),
+		token:      token,
+		store:      store,
+		filter:     filter,
+		checkpoint: checkpointFile,
+		client:     &http.Client{Timeout: pollTimeout + 30*time.Second},
+	}, nil
+}
+
+// Run replicates changes until the replica has caught up if once is true, otherwise
+// it keeps following the changes feed until ctx is done. Errors are retried with
+// backoff when running continuously.
+func (rep *Replicator) Run(ctx context.Context, once bool) error {
+	since, err := rep.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	slog.Info("starting replication", "from", rep.from, "since", since)
+	wait := time.Second
+	for ctx.Err() == nil {
+		next, caughtUp, err := rep.replicate(ctx, since, !once)
+		if err == nil {
+			wait = time.Second
+			since = next
+			if once && caughtUp {
+				slog.Info("replication done", "from", rep.from, "seq", since)
+				return nil
+			}
+			continue
+		}
+		if once {
+			return err
+		}
+		slog.Error("replication failed, retrying", "from", rep.from, "retry", wait, "cause", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		wait = min(wait*2, maxRetryWait)
+	}
+	return ctx.Err()
+}
+
+// replicate copies the packages in a batch of changes after since and returns the
+// sequence number to continue from. Caught up is true if there were no more changes.
+func (rep *Replicator) replicate(ctx context.Context, since int64, longpoll bool) (int64, bool, error) {
+	feed, err := rep.changes(ctx, since, longpoll)
+	if err != nil {
+		return since, false, err
+	}
+	// packages are copied once per batch, unpublishing if any change was an unpublish
+	type pending struct {
+		pkg       storage.Package
+		unpublish bool
+	}
+	pkgs := []*pending{}
+	found := map[string]*pending{}
+	for _, c := range feed.Results {
+		s, name, _ := strings.Cut(c.Package, "/")
+		if name == "" {
+			s, name = "", c.Package
+		}
+		pkg, err := storage.NewPackage(c.Registry, s, name)
+		if err != nil {
+			slog.Warn("skipping invalid change", "seq", c.Seq, "id", c.ID, "cause", err)
+			continue
+		}
+		if !rep.filter.Match(pkg) {
+			continue
+		}
+		p, ok := found[c.ID]
+		if !ok {
+			p = &pending{pkg: pkg}
+			found[c.ID] = p
+			pkgs = append(pkgs, p)
+		}
+		p.unpublish = p.unpublish || c.Action == changes.ActionUnpublish
+	}
+	for _, p := range pkgs {
+		if err := rep.Package(ctx, p.pkg, p.unpublish); err != nil {
+			return since, false, fmt.Errorf("error replicating %s: %w", p.pkg.String(), err)
+		}
+	}
+	if feed.LastSeq == since {
+		return since, true, nil
+	}
+	if err := rep.saveCheckpoint(feed.LastSeq); err != nil {
+		return since, false, err
+	}
+	return feed.LastSeq, len(feed.Results) < batchSize && !longpoll, nil
+}
+
+type changesFeed struct {
+	Results []changes.Change `json:"results"`
+	LastSeq int64            `json:"last_seq"`
+}
+
+func (rep *Replicator) changes(ctx context.Context, since int64, longpoll bool) (changesFeed, error) {
+	feed := changesFeed{}
+	query := url.Values{"since": {fmt.Sprint(since)}, "limit": {fmt.Sprint(batchSize)}}
+	if longpoll {
+		query.Set("feed", "longpoll")
+		query.Set("timeout", fmt.Sprint(pollTimeout.Milliseconds()))
+	}
+	data, err := rep.get(ctx, "/_changes?"+query.Encode())
+	if err != nil {
+		return feed, err
+	}
+	return feed, json.Unmarshal(data, &feed)
+}
+
+// Package copies missing tarballs and the assets of a package from the primary
+// and indexes it. If unpublish is true tarballs no longer on the primary are
+// removed, the package is removed if it's no longer on the primary at all.
+func (rep *Replicator) Package(ctx context.Context, pkg storage.Package, unpublish bool) error {
+	data, err := rep.get(ctx, "/api/replicate/"+apiPath(pkg))
+	if errors.Is(err, storage.ErrNotFound) {
+		if unpublish {
+			slog.Info("removing package unpublished on primary", "pkg", pkg.String())
+			return rep.store.DeletePackage(pkg)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	doc := Package{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	local, err := rep.store.Tarballs(pkg)
+	if err != nil {
+		return err
+	}
+	for name, integrity := range doc.Tarballs {
+		tarball := storage.NewTarball(pkg, name)
+		if slices.Contains(local, tarball) {
+			continue
+		}
+		data, err := rep.get(ctx, "/api/tarball/"+apiPath(pkg)+"/"+url.PathEscape(name))
+		if err != nil {
+			return err
+		}
+		if err := storage.VerifyTarball(tarball, data, integrity); err != nil {
+			return err
+		}
+		slog.Info("replicating tarball", "tarball", tarball.String())
+		if err := rep.store.PutTarball(tarball, data); err != nil {
+			return err
+		}
+	}
+	if unpublish {
+		for _, tarball := range local {
+			if _, found := doc.Tarballs[tarball.Name]; !found {
+				slog.Info("removing tarball unpublished on primary", "tarball", tarball.String())
+				if err := rep.store.DeleteTarball(tarball); err != nil && !errors.Is(err, storage.ErrNotFound) {
+					return err
+				}
+			}
+		}
+	}
+	for name, data := range doc.Assets {
+		if err := rep.store.PutPackageAsset(pkg, name, data); err != nil {
+			return err
+		}
+	}
+	_, err = rep.store.Index(pkg)
+	return err
+}
+
+func (rep *Replicator) get(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rep.from+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rep.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rep.token)
+	}
+	res, err := rep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, storage.ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %s for %s", res.Status, uri)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (rep *Replicator) loadCheckpoint() (int64, error) {
+	data, err := os.ReadFile(rep.checkpoint)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	cp := checkpoint{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return 0, err
+	}
+	// start over when replicating from another primary
+	if cp.From != rep.from {
+		return 0, nil
+	}
+	return cp.Since, nil
+}
+
+func (rep *Replicator) saveCheckpoint(since int64) error {
+	data, err := json.Marshal(checkpoint{From: rep.from, Since: since})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rep.checkpoint), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(rep.checkpoint, data, 0644)
+}
+
+// apiPath returns the registry and package name used in API paths, scoped package
+// names are escaped like npm does.
+func apiPath(pkg storage.Package) string {
+	return pkg.Registry + "/" + url.PathEscape(pkg.FullName())
+}
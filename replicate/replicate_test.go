@@ -0,0 +1,98 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"enpeeem/changes"
+	"enpeeem/storage"
+	"enpeeem/storage/storagetest"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	type Test struct {
+		Filter   Filter
+		Package  storage.Package
+		Expected bool
+	}
+	react := storage.Package{Registry: "registry.npmjs.org", Name: "react"}
+	types := storage.Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "react"}
+	tests := []Test{
+		{Filter: Filter{}, Package: react, Expected: true},
+		{Filter: Filter{Registries: []string{"registry.npmjs.org"}}, Package: react, Expected: true},
+		{Filter: Filter{Registries: []string{"npm.example.com"}}, Package: react, Expected: false},
+		{Filter: Filter{Scopes: []string{"@types"}}, Package: types, Expected: true},
+		{Filter: Filter{Scopes: []string{"@types"}}, Package: react, Expected: false},
+		{Filter: Filter{Packages: []string{"react*"}}, Package: react, Expected: true},
+		{Filter: Filter{Packages: []string{"@types/*"}}, Package: types, Expected: true},
+		{Filter: Filter{Packages: []string{"@types/*"}, Registries: []string{"npm.example.com"}}, Package: types, Expected: false},
+	}
+	for _, test := range tests {
+		if actual := test.Filter.Match(test.Package); actual != test.Expected {
+			t.Errorf("%v %s: expected %v but got %v", test.Filter, test.Package.String(), test.Expected, actual)
+		}
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	tgz := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"name":"@types/react","version":"18.0.0"}`})
+	primary := http.NewServeMux()
+	primary.HandleFunc("GET /_changes", func(w http.ResponseWriter, r *http.Request) {
+		results := []changes.Change{}
+		if r.URL.Query().Get("since") == "0" {
+			results = append(results,
+				changes.Change{Seq: 1, ID: "registry.npmjs.org/@types/react", Registry: "registry.npmjs.org", Package: "@types/react", Version: "18.0.0", Action: changes.ActionStash},
+				changes.Change{Seq: 2, ID: "registry.npmjs.org/left-pad", Registry: "registry.npmjs.org", Package: "left-pad", Version: "1.0.0", Action: changes.ActionStash},
+			)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results, "last_seq": 2})
+	})
+	primary.HandleFunc("GET /api/replicate/registry.npmjs.org/@types%2Freact", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Package{
+			Tarballs: map[string]string{"react-18.0.0.tgz": storage.Integrity(tgz)},
+			Assets:   map[string][]byte{storage.DistTagsAssetName: []byte(`{"beta":"18.0.0"}`)},
+		})
+	})
+	primary.HandleFunc("GET /api/tarball/registry.npmjs.org/@types%2Freact/react-18.0.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(tgz)
+	})
+	server := httptest.NewServer(primary)
+	defer server.Close()
+
+	dir := t.TempDir()
+	store := storage.NewFileStore(dir, dir)
+	checkpoint := filepath.Join(dir, ".enpeeem", "replicate.json")
+	rep, err := New(server.URL, "token", store, Filter{Scopes: []string{"@types"}}, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.Run(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	types := storage.Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "react"}
+	pkmt, err := store.GetPackageMetadata(types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkmt.DistTags["beta"] != "18.0.0" {
+		t.Errorf("expected beta tag 18.0.0 but got %v", pkmt.DistTags)
+	}
+	if tarballs, _ := store.Tarballs(storage.Package{Registry: "registry.npmjs.org", Name: "left-pad"}); len(tarballs) != 0 {
+		t.Error("expected left-pad to be filtered")
+	}
+	if since, _ := rep.loadCheckpoint(); since != 2 {
+		t.Errorf("expected checkpoint 2 but got %d", since)
+	}
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlobDir is the directory in the storage path with the tarball contents of a
+// content-addressable store, tarball files are hard links to the blobs.
+const BlobDir = ".blobs"
+
+// ErrLinkCount is returned by garbage collection on platforms without link counts.
+var ErrLinkCount = errors.New("hard link counts are not supported on this platform")
+
+// blobLocks serialize linking to a blob with releasing it, so a blob isn't
+// removed between checking that it exists and linking a tarball to it.
+var blobLocks [64]sync.Mutex
+
+// lockBlob locks the stripe of blob and returns the function unlocking it.
+func lockBlob(blob string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Base(blob)))
+	mux := &blobLocks[h.Sum32()%uint32(len(blobLocks))]
+	mux.Lock()
+	return mux.Unlock
+}
+
+// NewCASFileStore returns a file store saving each distinct tarball once in the
+// blob directory, keyed by sha512. The tarball files of all registries are hard
+// links to the blobs, a blob is removed when the last tarball linking to it is
+// removed.
+func NewCASFileStore(dir, metadir string) *FileStore {
+	return &FileStore{dir: dir, metadir: metadir, cas: true}
+}
+
+func (fstore FileStore) blobFilename(data []byte) string {
+	sum := sha512.Sum512(data)
+	return fstore.blobPath(hex.EncodeToString(sum[:]))
+}
+
+func (fstore FileStore) blobPath(digest string) string {
+	return path.Join(fstore.dir, BlobDir, "sha512", digest[0:2], digest)
+}
+
+// recordedBlob returns the blob of a tarball according to the sha512 integrity in
+// the package metadata, empty if it isn't known.
+func (fstore FileStore) recordedBlob(pkmt PackageMetadata, version string) string {
+	algorithm, digest, found := strings.Cut(pkmt.Integrity(version), "-")
+	if !found || algorithm != "sha512" {
+		return ""
+	}
+	sum, err := base64.StdEncoding.DecodeString(digest)
+	if err != nil || len(sum) != sha512.Size {
+		return ""
+	}
+	return fstore.blobPath(hex.EncodeToString(sum))
+}
+
+// putBlob saves the data as a blob if it doesn't exist yet and links file to it.
+func (fstore FileStore) putBlob(file string, data []byte) error {
+	blob := fstore.blobFilename(data)
+	// the old content is only hashed if the file is replaced with other content
+	old := blob
+	if !same(blob, file) {
+		old = fstore.blobOf(file, "")
+	}
+	unlock := lockBlob(blob)
+	err := writeBlob(blob, data)
+	if err == nil {
+		err = link(blob, file)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+	if old != blob {
+		return release(old)
+	}
+	return nil
+}
+
+// writeBlob writes a blob to a temporary file first so a blob is always complete.
+func writeBlob(blob string, data []byte) error {
+	if _, err := os.Stat(blob); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(blob), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// linking fails if the blob was written concurrently, it has the same content
+	if err := os.Link(tmp.Name(), blob); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// link replaces file with a hard link to blob.
+func link(blob, file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	if same(blob, file) {
+		return nil
+	}
+	tmp := fmt.Sprintf("%s.link-%x", file, rand.Uint64())
+	if err := os.Link(blob, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// same returns true if both files exist and are the same file.
+func same(a, b string) bool {
+	ainfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	binfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ainfo, binfo)
+}
+
+// blobOf returns the blob the file is linked to, empty if there is none. The file
+// is only hashed if it isn't linked to the recorded blob.
+func (fstore FileStore) blobOf(file, recorded string) string {
+	if recorded != "" && same(recorded, file) {
+		return recorded
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	blob := fstore.blobFilename(data)
+	if !same(blob, file) {
+		return ""
+	}
+	return blob
+}
+
+// release removes the blob if no tarball links to it anymore.
+func release(blob string) error {
+	if blob == "" {
+		return nil
+	}
+	defer lockBlob(blob)()
+	info, err := os.Stat(blob)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n, ok := linkCount(info); ok && n <= 1 {
+		return os.Remove(blob)
+	}
+	return nil
+}
+
+// removeLinked removes the file and releases the blob it links to.
+func (fstore FileStore) removeLinked(file, recorded string) error {
+	blob := fstore.blobOf(file, recorded)
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	return release(blob)
+}
+
+// linkedBlobs returns the blobs linked to by files in the package tarball
+// directory, including quarantined tarballs.
+func (fstore FileStore) linkedBlobs(pkg Package) []string {
+	entries, err := os.ReadDir(fstore.tarballDir(pkg))
+	if err != nil {
+		return nil
+	}
+	pkmt, _ := fstore.GetPackageMetadata(pkg)
+	blobs := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			tarball := NewTarball(pkg, strings.TrimSuffix(entry.Name(), QuarantineSuffix))
+			recorded := fstore.recordedBlob(pkmt, tarball.Version())
+			if blob := fstore.blobOf(path.Join(fstore.tarballDir(pkg), entry.Name()), recorded); blob != "" {
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+	return blobs
+}
+
+// CollectGarbage removes blobs no tarball links to and leftover temporary files,
+// returning the number of removed blobs and their total size.
+func (fstore FileStore) CollectGarbage() (int, int64, error) {
+	removed, freed := 0, int64(0)
+	root := path.Join(fstore.dir, BlobDir)
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == root {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !strings.HasPrefix(d.Name(), ".tmp-") {
+			defer lockBlob(file)()
+		}
+		info, err := os.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		n, ok := linkCount(info)
+		if !ok {
+			return ErrLinkCount
+		}
+		if n > 1 && !strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// MigrateToCAS converts the tarballs of a package in place to hard links to
+// blobs, returning the number of tarballs linked to an existing blob and the
+// disk space saved.
+func (fstore FileStore) MigrateToCAS(pkg Package) (int, int64, error) {
+	tarballs, err := fstore.Tarballs(pkg)
+	if err != nil {
+		return 0, 0, err
+	}
+	deduplicated, saved := 0, int64(0)
+	for _, tarball := range tarballs {
+		file := fstore.tarballFilename(tarball)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return deduplicated, saved, err
+		}
+		blob := fstore.blobFilename(data)
+		if same(blob, file) {
+			continue
+		}
+		linked, err := migrate(blob, file)
+		if err != nil {
+			return deduplicated, saved, err
+		}
+		if linked {
+			deduplicated++
+			saved += int64(len(data))
+		}
+	}
+	return deduplicated, saved, nil
+}
+
+// migrate makes file the blob if it doesn't exist yet, else replaces file with a
+// link to the blob and returns true.
+func migrate(blob, file string) (bool, error) {
+	defer lockBlob(blob)()
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		// the first copy of the content becomes the blob without copying it
+		if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+			return false, err
+		}
+		err := os.Link(file, blob)
+		if err == nil {
+			return false, nil
+		}
+		// another process created the blob since it was checked, link to it instead
+		if !errors.Is(err, fs.ErrExist) {
+			return false, err
+		}
+	}
+	return true, link(blob, file)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "io/fs"
+
+// linkCount is not available, unused blobs are kept on platforms without hard
+// link counts.
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
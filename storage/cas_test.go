@@ -0,0 +1,139 @@
+//go:build unix
+
+package storage
+
+import (
+	"enpeeem/storage/storagetest"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestContentAddressedStore(t *testing.T) {
+	dir := t.TempDir()
+	plain := NewFileStore(dir, dir)
+	data := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"name":"x","version":"1.0.0"}`})
+	npm := NewTarball(Package{Registry: "registry.npmjs.org", Name: "x"}, "x-1.0.0.tgz")
+	mirror := NewTarball(Package{Registry: "mirror.example.com", Name: "x"}, "x-1.0.0.tgz")
+	for _, tarball := range []Tarball{npm, mirror} {
+		if err := plain.PutTarball(tarball, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewCASFileStore(dir, dir)
+	deduplicated, saved := 0, int64(0)
+	for _, pkg := range []Package{npm.Package(), mirror.Package()} {
+		n, size, err := store.MigrateToCAS(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deduplicated += n
+		saved += size
+	}
+	if deduplicated != 1 || saved != int64(len(data)) {
+		t.Errorf("expected 1 deduplicated tarball saving %d bytes but got %d saving %d", len(data), deduplicated, saved)
+	}
+	blob := store.blobFilename(data)
+	links := func() uint64 {
+		info, err := os.Stat(blob)
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := linkCount(info)
+		return n
+	}
+	if n := links(); n != 3 {
+		t.Errorf("expected 3 links to the blob but got %v", n)
+	}
+
+	// a tarball of another package with the same content reuses the blob
+	other := NewTarball(Package{Registry: "registry.npmjs.org", Scope: "@s", Name: "x"}, "x-1.0.0.tgz")
+	if err := store.PutTarball(other, data); err != nil {
+		t.Fatal(err)
+	}
+	if n := links(); n != 4 {
+		t.Errorf("expected 4 links to the blob but got %v", n)
+	}
+
+	// overwriting a linked tarball without CAS must not change the blob
+	if err := plain.PutTarball(other, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetTarball(npm); string(got) != string(data) {
+		t.Error("expected the blob to be unchanged")
+	}
+
+	if err := store.DeleteTarball(npm); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePackage(mirror.Package()); err != nil {
+		t.Fatal(err)
+	}
+	if n := links(); n != 0 {
+		t.Errorf("expected the unreferenced blob to be removed but it has %v links", n)
+	}
+
+	// blobs left without references are removed by garbage collection
+	if err := store.PutTarball(npm, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(dir, "registry.npmjs.org", "x", "x-1.0.0.tgz")); err != nil {
+		t.Fatal(err)
+	}
+	removed, freed, err := store.CollectGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != int64(len(data)) {
+		t.Errorf("expected 1 removed blob of %d bytes but got %d of %d", len(data), removed, freed)
+	}
+	if n := links(); n != 0 {
+		t.Errorf("expected the blob to be removed but it has %v links", n)
+	}
+}
+
+func TestConcurrentBlobs(t *testing.T) {
+	store := NewCASFileStore(t.TempDir(), t.TempDir())
+	data := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"name":"x","version":"1.0.0"}`})
+	tarballs := []Tarball{}
+	for i := 0; i < 16; i++ {
+		tarballs = append(tarballs, NewTarball(Package{Registry: "registry.npmjs.org", Name: fmt.Sprintf("x%d", i)}, fmt.Sprintf("x%d-1.0.0.tgz", i)))
+	}
+	wg := sync.WaitGroup{}
+	for _, tarball := range tarballs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := store.PutTarball(tarball, data); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := store.DeleteTarball(tarball); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if err := store.PutTarball(tarball, data); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	info, err := os.Stat(store.blobFilename(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := linkCount(info); n != uint64(len(tarballs)+1) {
+		t.Errorf("expected %d links to the blob but got %d", len(tarballs)+1, n)
+	}
+}
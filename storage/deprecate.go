@@ -0,0 +1,32 @@
+package storage
+
+// GetDeprecations returns deprecation messages for a package keyed by version.
+// Deprecations are kept separate from the package metadata so they survive
+// reindexing.
+func GetDeprecations(store Store, pkg Package) (map[string]string, error) {
+	deprecations := map[string]string{}
+	err := GetJSONAsset(store, pkg, DeprecationsAssetName, &deprecations)
+	return deprecations, err
+}
+
+// PutDeprecations saves deprecation messages for a package keyed by version. Package
+// metadata must be reindexed for the deprecations to take effect.
+func PutDeprecations(store Store, pkg Package, deprecations map[string]string) error {
+	return PutJSONAsset(store, pkg, DeprecationsAssetName, deprecations)
+}
+
+// SetDeprecations sets the deprecated field for versions with a deprecation
+// message and removes it from all other versions.
+func (pm *PackageMetadata) SetDeprecations(deprecations map[string]string) {
+	for v, version := range pm.Versions {
+		version, ok := version.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if msg, found := deprecations[v]; found && msg != "" {
+			version["deprecated"] = msg
+		} else {
+			delete(version, "deprecated")
+		}
+	}
+}
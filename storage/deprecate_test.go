@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestSetDeprecations(t *testing.T) {
+	pkmt := NewPackageMetadata("", "create-vite", map[string]interface{}{
+		"1.0.0": map[string]interface{}{"version": "1.0.0"},
+		"2.0.0": map[string]interface{}{"version": "2.0.0", "deprecated": "old message"},
+		"3.0.0": map[string]interface{}{"version": "3.0.0"},
+	})
+	pkmt.SetDeprecations(map[string]string{"1.0.0": "use 3.0.0", "3.0.0": ""})
+
+	expected := map[string]string{"1.0.0": "use 3.0.0", "2.0.0": "", "3.0.0": ""}
+	for v, msg := range expected {
+		actual, _ := pkmt.Versions[v].(map[string]interface{})["deprecated"].(string)
+		if actual != msg {
+			t.Errorf("expected version %s to be deprecated with %q but got %q", v, msg, actual)
+		}
+	}
+}
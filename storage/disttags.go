@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var ErrInvalidDistTag = errors.New("invalid dist-tag")
+
+// distTagsMux serializes updates of the dist-tags asset, which are read, changed
+// and written again.
+var distTagsMux sync.Mutex
+
+// GetDistTags returns the dist-tags explicitly set for a package. Tags are kept
+// separate from the package metadata so they survive reindexing.
+func GetDistTags(store Store, pkg Package) (map[string]string, error) {
+	tags := map[string]string{}
+	err := GetJSONAsset(store, pkg, DistTagsAssetName, &tags)
+	return tags, err
+}
+
+// PutDistTags saves the dist-tags explicitly set for a package. Package metadata
+// must be reindexed for the tags to take effect.
+func PutDistTags(store Store, pkg Package, tags map[string]string) error {
+	return PutJSONAsset(store, pkg, DistTagsAssetName, tags)
+}
+
+// UpdateDistTags changes the dist-tags explicitly set for a package with update,
+// updates of all packages are serialized so no change is lost.
+func UpdateDistTags(store Store, pkg Package, update func(tags map[string]string)) error {
+	distTagsMux.Lock()
+	defer distTagsMux.Unlock()
+	tags, err := GetDistTags(store, pkg)
+	if err != nil {
+		return err
+	}
+	update(tags)
+	return PutDistTags(store, pkg, tags)
+}
+
+// ValidateDistTag returns ErrInvalidDistTag for tags npm rejects, empty tags and
+// tags that are semver ranges since they can't be told apart from versions.
+func ValidateDistTag(tag string) error {
+	if strings.TrimSpace(tag) == "" || strings.ContainsAny(tag, "/ \t\r\n") {
+		return fmt.Errorf("%w %q", ErrInvalidDistTag, tag)
+	}
+	if _, err := semver.NewConstraint(tag); err == nil {
+		return fmt.Errorf("%w %q, it's a semver range", ErrInvalidDistTag, tag)
+	}
+	return nil
+}
+
+// SetDistTags replaces the metadata dist-tags with the given tags, tags pointing
+// to versions not found in the metadata are ignored. The latest tag is set to
+// the latest stable version unless it's explicitly given.
+func (pm *PackageMetadata) SetDistTags(tags map[string]string) {
+	pm.DistTags = map[string]string{}
+	for tag, version := range tags {
+		if _, found := pm.Versions[version]; found {
+			pm.DistTags[tag] = version
+		}
+	}
+	if _, found := pm.DistTags["latest"]; !found {
+		pm.SetLatestVersion()
+	}
+}
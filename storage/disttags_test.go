@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSetDistTags(t *testing.T) {
+	versions := map[string]interface{}{"1.0.0": "", "2.0.0": "", "3.0.0-beta.1": ""}
+	type Test struct {
+		Tags     map[string]string
+		Expected map[string]string
+	}
+	tests := []Test{
+		{
+			Tags:     map[string]string{},
+			Expected: map[string]string{"latest": "2.0.0"},
+		},
+		{
+			Tags:     map[string]string{"next": "3.0.0-beta.1"},
+			Expected: map[string]string{"latest": "2.0.0", "next": "3.0.0-beta.1"},
+		},
+		{
+			Tags:     map[string]string{"latest": "1.0.0", "next": "3.0.0-beta.1"},
+			Expected: map[string]string{"latest": "1.0.0", "next": "3.0.0-beta.1"},
+		},
+		{
+			Tags:     map[string]string{"latest": "4.0.0", "beta": "4.0.0-beta.1"},
+			Expected: map[string]string{"latest": "2.0.0"},
+		},
+	}
+
+	for _, test := range tests {
+		pkmt := NewPackageMetadata("", "create-vite", versions)
+		pkmt.SetDistTags(test.Tags)
+		if len(pkmt.DistTags) != len(test.Expected) {
+			t.Errorf("expected %v dist-tags but found %v", len(test.Expected), len(pkmt.DistTags))
+		}
+		for tag, version := range test.Expected {
+			if pkmt.DistTags[tag] != version {
+				t.Errorf("expected dist-tag %s to be %s but got %s", tag, version, pkmt.DistTags[tag])
+			}
+		}
+	}
+}
+
+func TestValidateDistTag(t *testing.T) {
+	type Test struct {
+		Tag   string
+		Valid bool
+	}
+	tests := []Test{
+		{"latest", true},
+		{"next", true},
+		{"beta-2", true},
+		{"", false},
+		{" ", false},
+		{"a/b", false},
+		{"1.0.0", false},
+		{"v2", false},
+		{"^1.2", false},
+		{"x", false},
+	}
+	for _, test := range tests {
+		err := ValidateDistTag(test.Tag)
+		if (err == nil) != test.Valid {
+			t.Errorf("%q: expected valid %v but got %v", test.Tag, test.Valid, err)
+		}
+	}
+}
+
+func TestUpdateDistTags(t *testing.T) {
+	store := NewMemoryStore()
+	pkg := Package{Registry: "registry.npmjs.org", Name: "x"}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := UpdateDistTags(store, pkg, func(tags map[string]string) {
+				tags[fmt.Sprintf("tag%d", i)] = "1.0.0"
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	tags, err := GetDistTags(store, pkg)
+	if err != nil || len(tags) != 20 {
+		t.Errorf("expected 20 dist-tags but got %v %v", tags, err)
+	}
+}
@@ -3,26 +3,30 @@ package storage
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/fs"
-	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
-	"sync"
-
-	"github.com/alitto/pond"
 )
 
 const (
 	PackageMetadataAssetName = "metadata.json"
+	DistTagsAssetName        = "dist-tags.json"
+	DeprecationsAssetName    = "deprecations.json"
+	TimeAssetName            = "time.json"
+	PublishedAssetName       = "published.json"
+	IntegrityAssetName       = "integrity.json"
+	TamperingAssetName       = "tampering.json"
+	QuarantineSuffix         = ".quarantine"
 )
 
 type FileStore struct {
 	dir     string
 	metadir string
+	cas     bool
+	sql     *SQLIndex
 }
 
 func NewFileStore(dir, metadir string) *FileStore {
@@ -45,21 +49,69 @@ func (fstore FileStore) packageFilename(pkg Package) string {
 	return path.Join(fstore.packageDir(pkg), PackageMetadataAssetName)
 }
 
+// GetPackageAsset reads a named file stored together with the package metadata.
+func (fstore FileStore) GetPackageAsset(pkg Package, name string) ([]byte, error) {
+	data, err := os.ReadFile(path.Join(fstore.packageDir(pkg), name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, ErrNotFound
+	}
+	return data, err
+}
+
+// PutPackageAsset writes a named file together with the package metadata.
+func (fstore FileStore) PutPackageAsset(pkg Package, name string, data []byte) error {
+	if err := os.MkdirAll(fstore.packageDir(pkg), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(fstore.packageDir(pkg), name), data, 0644)
+}
+
 func (fstore FileStore) PutPackage(pkg Package, data []byte) error {
 	dir := fstore.packageDir(pkg)
 	file := fstore.packageFilename(pkg)
 	if err := os.MkdirAll(dir, 0750); err != nil {
 		return err
 	}
-	return os.WriteFile(file, data, 0644)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	fstore.updateSQLIndex(func(idx *SQLIndex) error {
+		pkmt := PackageMetadata{}
+		if err := json.Unmarshal(data, &pkmt); err != nil {
+			return err
+		}
+		return idx.PutPackage(pkg, pkmt, fstore.tarballInfo(pkg))
+	})
+	return nil
 }
 
 func (fstore FileStore) PutTarball(tarball Tarball, data []byte) error {
+	if err := fstore.putTarball(tarball, data); err != nil {
+		return err
+	}
+	fstore.updateSQLIndex(func(idx *SQLIndex) error {
+		if err := idx.AddPackage(tarball.Package()); err != nil {
+			return err
+		}
+		return idx.SetTarballInfo(tarball, fstore.tarballInfo(tarball.Package())(tarball.Name))
+	})
+	return nil
+}
+
+func (fstore FileStore) putTarball(tarball Tarball, data []byte) error {
 	dir := fstore.tarballDir(tarball.Package())
 	file := fstore.tarballFilename(tarball)
+	if fstore.cas {
+		return fstore.putBlob(file, data)
+	}
 	if err := os.MkdirAll(dir, 0750); err != nil {
 		return err
 	}
+	// the file may be a hard link to a blob shared with other tarballs, which must
+	// not be overwritten
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	return os.WriteFile(file, data, 0644)
 }
 
@@ -94,7 +146,87 @@ func (fstore FileStore) GetTarball(tarball Tarball) ([]byte, error) {
 	return data, err
 }
 
+func (fstore FileStore) StatTarball(tarball Tarball) (TarballInfo, error) {
+	info, err := os.Stat(fstore.tarballFilename(tarball))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return TarballInfo{}, ErrNotFound
+		}
+		return TarballInfo{}, err
+	}
+	return TarballInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// QuarantineTarball renames the tarball file so it's no longer found as a tarball
+// but kept on disk for inspection.
+func (fstore FileStore) QuarantineTarball(tarball Tarball) error {
+	file := fstore.tarballFilename(tarball)
+	err := os.Rename(file, file+QuarantineSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err == nil {
+		fstore.updateSQLIndex(func(idx *SQLIndex) error {
+			return idx.DeleteTarball(tarball)
+		})
+	}
+	return err
+}
+
+// DeleteTarball removes the tarball file. Package metadata must be reindexed
+// afterwards.
+func (fstore FileStore) DeleteTarball(tarball Tarball) error {
+	var err error
+	if fstore.cas {
+		pkmt, _ := fstore.GetPackageMetadata(tarball.Package())
+		err = fstore.removeLinked(fstore.tarballFilename(tarball), fstore.recordedBlob(pkmt, tarball.Version()))
+	} else {
+		err = os.Remove(fstore.tarballFilename(tarball))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err == nil {
+		fstore.updateSQLIndex(func(idx *SQLIndex) error {
+			return idx.DeleteTarball(tarball)
+		})
+	}
+	return err
+}
+
+// DeletePackage removes all tarballs, metadata and assets for a package.
+func (fstore FileStore) DeletePackage(pkg Package) error {
+	blobs := []string{}
+	if fstore.cas {
+		blobs = fstore.linkedBlobs(pkg)
+	}
+	if err := os.RemoveAll(fstore.tarballDir(pkg)); err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if err := release(blob); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(fstore.packageDir(pkg)); err != nil {
+		return err
+	}
+	fstore.updateSQLIndex(func(idx *SQLIndex) error {
+		return idx.DeletePackage(pkg)
+	})
+	return nil
+}
+
+// Packages returns all packages, from the SQL index if there is one.
 func (fstore FileStore) Packages() ([]Package, error) {
+	if fstore.sql != nil {
+		return fstore.sql.Packages()
+	}
+	return fstore.PackagesOnDisk()
+}
+
+// PackagesOnDisk lists the package directories, also when the SQL index is used.
+func (fstore FileStore) PackagesOnDisk() ([]Package, error) {
 	pkgs := []Package{}
 	root := os.DirFS(fstore.dir)
 	files, err := fs.Glob(root, "*/*")
@@ -117,6 +249,10 @@ func (fstore FileStore) Packages() ([]Package, error) {
 	files = append(files, unscopedFiles...)
 
 	for _, file := range files {
+		// directories like .enpeeem are used for state and are not registries
+		if strings.HasPrefix(file, ".") {
+			continue
+		}
 		pkg, err := PackageMetadataFromURI(file)
 		if err != nil {
 			return pkgs, err
@@ -141,65 +277,7 @@ func (fstore FileStore) Tarballs(pkg Package) ([]Tarball, error) {
 }
 
 func (fstore FileStore) Index(pkg Package) (PackageMetadata, error) {
-	tarballs, err := fstore.Tarballs(pkg)
-	if err != nil {
-		return PackageMetadata{}, err
-	}
-
-	slog.Debug("number of tarballs found", "tarballs", len(tarballs), "pkg", pkg.String())
-	pkmt, err := fstore.GetPackageMetadata(pkg)
-	if err != nil {
-		if !errors.Is(err, ErrNotFound) {
-			return pkmt, fmt.Errorf("error opening existing package metadata file for %s: %w", pkg.String(), err)
-		}
-		slog.Debug("creating new package metadata, existing not found", "pkg", pkg.String())
-		pkmt = NewPackageMetadata("", pkg.Name, map[string]interface{}{})
-	} else {
-		// remove metadata for tarballs that no longer exist on disk
-		pkmt.PruneVersions(tarballs)
-	}
-
-	// we don't need to process tarballs already indexed in the package metadata file
-	tarballs = slices.DeleteFunc(tarballs, func(tarball Tarball) bool {
-		v := fileVersion(pkg.Name, tarball.Name)
-		_, found := pkmt.Versions[v]
-		return found
-	})
-
-	slog.Debug("unindexed tarballs", "tarballs", len(tarballs), "pkg", pkg.String())
-
-	pool := pond.New(5, 0)
-	mux := sync.Mutex{}
-	for _, tarball := range tarballs {
-		pool.Submit(func() {
-			slog.Debug("loading tarball", "tarball", tarball.String(), "pkg", pkg.String())
-			data, err := fstore.GetTarball(tarball)
-			if err != nil {
-				slog.Error("could not load tarball, skipping", "tarball", tarball.String(), "error", err)
-				return
-			}
-			verNo, pkgjson, err := pkmt.ParsePackageJson(tarball, data)
-			if err != nil {
-				slog.Error("could not parse package.json", "tarball", tarball.String(), "error", err)
-				return
-			}
-			mux.Lock()
-			defer mux.Unlock()
-			pkmt.Versions[verNo] = pkgjson
-			pkmt.SetLatestVersion()
-		})
-	}
-	pool.StopAndWait()
-	jb, err := json.MarshalIndent(pkmt, "", "   ")
-	if err != nil {
-		return pkmt, err
-	}
-	slog.Debug("create directory if not exists", "pkg", pkg.String(), "dir", fstore.packageDir(pkg))
-	if err := os.MkdirAll(fstore.packageDir(pkg), 0750); err != nil {
-		return pkmt, err
-	}
-	slog.Debug("writing new package metadata file", "pkg", pkg.String(), "file", fstore.packageFilename(pkg))
-	return pkmt, os.WriteFile(fstore.packageFilename(pkg), jb, 0644)
+	return index(fstore, pkg)
 }
 
 func fileVersion(pkgName, filename string) string {
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FilterVersions removes versions from raw package metadata for which keep returns
+// false. Keep is called with the version and it's publish time, found is false if
+// the publish time is not known. Dist-tags pointing to removed versions are removed,
+// if the latest tag is removed it's set to the latest remaining stable version.
+// The created and modified times are set to the earliest and latest publish time
+// of the remaining versions. Fields not related to versions are left untouched.
+func FilterVersions(data []byte, keep func(version string, published time.Time, found bool) bool) ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, err
+	}
+	versions := map[string]json.RawMessage{}
+	times := map[string]string{}
+	tags := map[string]string{}
+	for field, v := range map[string]any{"versions": &versions, "time": &times, "dist-tags": &tags} {
+		if raw, found := doc[field]; found {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return data, err
+			}
+		}
+	}
+
+	removed := false
+	for v := range versions {
+		published, err := time.Parse(time.RFC3339, times[v])
+		if keep(v, published, err == nil) {
+			continue
+		}
+		delete(versions, v)
+		delete(times, v)
+		removed = true
+	}
+	if !removed {
+		return data, nil
+	}
+	for tag, v := range tags {
+		if _, found := versions[v]; !found {
+			delete(tags, tag)
+		}
+	}
+	if _, found := tags["latest"]; !found {
+		remaining := []string{}
+		for v := range versions {
+			remaining = append(remaining, v)
+		}
+		if latest := latestStableVersion(remaining); latest != "" {
+			tags["latest"] = latest
+		}
+	}
+
+	for field, v := range map[string]any{"versions": versions, "dist-tags": tags} {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return data, err
+		}
+		doc[field] = raw
+	}
+	if _, found := doc["time"]; found {
+		var created, modified time.Time
+		for v, t := range times {
+			if v == "created" || v == "modified" {
+				continue
+			}
+			if _, found := versions[v]; !found {
+				continue
+			}
+			if pt, err := time.Parse(time.RFC3339, t); err == nil {
+				if created.IsZero() || pt.Before(created) {
+					created = pt
+				}
+				if pt.After(modified) {
+					modified = pt
+				}
+			}
+		}
+		delete(times, "created")
+		delete(times, "modified")
+		if !created.IsZero() {
+			times["created"] = created.UTC().Format(TimeLayout)
+			times["modified"] = modified.UTC().Format(TimeLayout)
+		}
+		raw, err := json.Marshal(times)
+		if err != nil {
+			return data, err
+		}
+		doc["time"] = raw
+	}
+	return json.Marshal(doc)
+}
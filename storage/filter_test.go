@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFilterVersions(t *testing.T) {
+	data := []byte(`{
+		"name": "create-vite",
+		"readme": "keep me",
+		"dist-tags": {"latest": "3.0.0", "next": "4.0.0-beta.1", "old": "1.0.0"},
+		"time": {"created": "2021-01-01T00:00:00.000Z", "modified": "2024-01-01T00:00:00.000Z", "1.0.0": "2021-01-01T00:00:00.000Z", "2.0.0": "2022-01-01T00:00:00.000Z", "3.0.0": "2023-01-01T00:00:00.000Z", "4.0.0-beta.1": "2024-01-01T00:00:00.000Z"},
+		"versions": {"1.0.0": {}, "2.0.0": {}, "3.0.0": {}, "4.0.0-beta.1": {}, "5.0.0": {}}
+	}`)
+	cutoff := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	actual, err := FilterVersions(data, func(_ string, published time.Time, found bool) bool {
+		return found && published.Before(cutoff)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := struct {
+		Readme   string                 `json:"readme"`
+		DistTags map[string]string      `json:"dist-tags"`
+		Time     map[string]string      `json:"time"`
+		Versions map[string]interface{} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(actual, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Readme != "keep me" {
+		t.Errorf("expected readme to be kept but got %q", doc.Readme)
+	}
+	if len(doc.Versions) != 2 || doc.Versions["1.0.0"] == nil || doc.Versions["2.0.0"] == nil {
+		t.Errorf("expected versions 1.0.0 and 2.0.0 but got %v", doc.Versions)
+	}
+	expectedTimes := map[string]string{
+		"1.0.0":    "2021-01-01T00:00:00.000Z",
+		"2.0.0":    "2022-01-01T00:00:00.000Z",
+		"created":  "2021-01-01T00:00:00.000Z",
+		"modified": "2022-01-01T00:00:00.000Z",
+	}
+	if len(doc.Time) != len(expectedTimes) {
+		t.Errorf("expected times %v but got %v", expectedTimes, doc.Time)
+	}
+	for v, expected := range expectedTimes {
+		if doc.Time[v] != expected {
+			t.Errorf("expected time for %s to be %s but got %s", v, expected, doc.Time[v])
+		}
+	}
+	expectedTags := map[string]string{"latest": "2.0.0", "old": "1.0.0"}
+	if len(doc.DistTags) != len(expectedTags) {
+		t.Errorf("expected dist-tags %v but got %v", expectedTags, doc.DistTags)
+	}
+	for tag, v := range expectedTags {
+		if doc.DistTags[tag] != v {
+			t.Errorf("expected dist-tag %s to be %s but got %s", tag, v, doc.DistTags[tag])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alitto/pond"
+)
+
+// index creates package metadata from the tarballs in a store, tarballs already in
+// the existing package metadata are not read again. Versions of tarballs no
+// longer in the store are removed.
+func index(store Store, pkg Package) (PackageMetadata, error) {
+	tarballs, err := store.Tarballs(pkg)
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+
+	slog.Debug("number of tarballs found", "tarballs", len(tarballs), "pkg", pkg.String())
+	pkmt, err := store.GetPackageMetadata(pkg)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return pkmt, fmt.Errorf("error opening existing package metadata file for %s: %w", pkg.String(), err)
+		}
+		slog.Debug("creating new package metadata, existing not found", "pkg", pkg.String())
+		pkmt = NewPackageMetadata("", pkg.Name, map[string]interface{}{})
+	} else {
+		// remove metadata for tarballs that no longer exist on disk
+		pkmt.PruneVersions(tarballs)
+	}
+
+	// publish time falls back to the tarball modification time if the remote
+	// registry publish time is not known
+	stashed := map[string]Tarball{}
+	for _, tarball := range tarballs {
+		stashed[tarball.Version()] = tarball
+	}
+	published := func(version string) time.Time {
+		if tarball, found := stashed[version]; found {
+			if info, err := store.StatTarball(tarball); err == nil {
+				return info.ModTime
+			}
+		}
+		return time.Now()
+	}
+
+	// we don't need to process tarballs already indexed in the package metadata file,
+	// except to detect install scripts in versions indexed before that was done
+	unchecked := []Tarball{}
+	tarballs = slices.DeleteFunc(tarballs, func(tarball Tarball) bool {
+		v := fileVersion(pkg.Name, tarball.Name)
+		meta, found := pkmt.Versions[v]
+		if version, ok := meta.(map[string]interface{}); ok {
+			if _, checked := version["hasInstallScript"]; !checked {
+				unchecked = append(unchecked, tarball)
+			}
+		}
+		return found
+	})
+
+	slog.Debug("unindexed tarballs", "tarballs", len(tarballs), "pkg", pkg.String())
+
+	pool := pond.New(5, 0)
+	mux := sync.Mutex{}
+	for _, tarball := range tarballs {
+		pool.Submit(func() {
+			slog.Debug("loading tarball", "tarball", tarball.String(), "pkg", pkg.String())
+			data, err := store.GetTarball(tarball)
+			if err != nil {
+				slog.Error("could not load tarball, skipping", "tarball", tarball.String(), "error", err)
+				return
+			}
+			verNo, pkgjson, err := pkmt.ParsePackageJson(tarball, data)
+			if err != nil {
+				slog.Error("could not parse package.json", "tarball", tarball.String(), "error", err)
+				return
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			pkmt.Versions[verNo] = pkgjson
+		})
+	}
+	for _, tarball := range unchecked {
+		pool.Submit(func() {
+			data, err := store.GetTarball(tarball)
+			if err != nil {
+				slog.Error("could not load tarball, skipping", "tarball", tarball.String(), "error", err)
+				return
+			}
+			mux.Lock()
+			version, _ := pkmt.Versions[fileVersion(pkg.Name, tarball.Name)].(map[string]interface{})
+			mux.Unlock()
+			if err := DetectInstallScript(tarball, data, version); err != nil {
+				slog.Error("could not detect install scripts", "tarball", tarball.String(), "error", err)
+			}
+		})
+	}
+	pool.StopAndWait()
+	tags, err := GetDistTags(store, pkg)
+	if err != nil {
+		return pkmt, err
+	}
+	pkmt.SetDistTags(tags)
+	deprecations, err := GetDeprecations(store, pkg)
+	if err != nil {
+		return pkmt, err
+	}
+	pkmt.SetDeprecations(deprecations)
+	times, err := GetTimes(store, pkg)
+	if err != nil {
+		return pkmt, err
+	}
+	pkmt.SetTimes(times, published)
+	jb, err := json.MarshalIndent(pkmt, "", "   ")
+	if err != nil {
+		return pkmt, err
+	}
+	slog.Debug("writing new package metadata", "pkg", pkg.String())
+	return pkmt, store.PutPackage(pkg, jb)
+}
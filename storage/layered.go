@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned when changing an object only found in a read-only layer.
+var ErrReadOnly = errors.New("object is in a read-only layer")
+
+// LayeredStore reads from several stores in order and writes only to the first,
+// for example a team's writable stash on top of a shared read-only stash.
+// Packages and tarballs are the union of all layers, indexing writes metadata
+// covering the versions from every layer to the top layer.
+type LayeredStore struct {
+	layers []Store
+}
+
+// NewLayeredStore returns a store writing to top and reading from top and then the
+// read-only layers in the given order.
+func NewLayeredStore(top Store, readOnly ...Store) *LayeredStore {
+	return &LayeredStore{layers: append([]Store{top}, readOnly...)}
+}
+
+func (lstore LayeredStore) top() Store {
+	return lstore.layers[0]
+}
+
+// first returns the result of get for the first layer where it's found.
+func first[T any](layers []Store, get func(Store) (T, error)) (T, error) {
+	var v T
+	for _, layer := range layers {
+		var err error
+		v, err = get(layer)
+		if !errors.Is(err, ErrNotFound) {
+			return v, err
+		}
+	}
+	return v, ErrNotFound
+}
+
+func (lstore LayeredStore) GetPackageMetadata(pkg Package) (PackageMetadata, error) {
+	return first(lstore.layers, func(s Store) (PackageMetadata, error) {
+		return s.GetPackageMetadata(pkg)
+	})
+}
+
+func (lstore LayeredStore) GetPackageMetadataRaw(pkg Package) ([]byte, error) {
+	return first(lstore.layers, func(s Store) ([]byte, error) {
+		return s.GetPackageMetadataRaw(pkg)
+	})
+}
+
+func (lstore LayeredStore) GetTarball(tarball Tarball) ([]byte, error) {
+	return first(lstore.layers, func(s Store) ([]byte, error) {
+		return s.GetTarball(tarball)
+	})
+}
+
+func (lstore LayeredStore) StatTarball(tarball Tarball) (TarballInfo, error) {
+	return first(lstore.layers, func(s Store) (TarballInfo, error) {
+		return s.StatTarball(tarball)
+	})
+}
+
+// GetPackageAsset returns the asset from the first layer that has it, so dist-tags
+// and other assets changed in the top layer override the read-only layers.
+func (lstore LayeredStore) GetPackageAsset(pkg Package, name string) ([]byte, error) {
+	return first(lstore.layers, func(s Store) ([]byte, error) {
+		return s.GetPackageAsset(pkg, name)
+	})
+}
+
+func (lstore LayeredStore) PutPackage(pkg Package, data []byte) error {
+	return lstore.top().PutPackage(pkg, data)
+}
+
+func (lstore LayeredStore) PutTarball(tarball Tarball, data []byte) error {
+	return lstore.top().PutTarball(tarball, data)
+}
+
+func (lstore LayeredStore) PutPackageAsset(pkg Package, name string, data []byte) error {
+	return lstore.top().PutPackageAsset(pkg, name, data)
+}
+
+// Packages returns the packages in all layers.
+func (lstore LayeredStore) Packages() ([]Package, error) {
+	pkgs := []Package{}
+	seen := map[Package]struct{}{}
+	for _, layer := range lstore.layers {
+		layerPkgs, err := layer.Packages()
+		if err != nil {
+			return pkgs, err
+		}
+		for _, pkg := range layerPkgs {
+			if _, found := seen[pkg]; !found {
+				seen[pkg] = struct{}{}
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// PackagesOnDisk returns the packages on disk in all layers.
+func (lstore LayeredStore) PackagesOnDisk() ([]Package, error) {
+	pkgs := []Package{}
+	seen := map[Package]struct{}{}
+	for _, layer := range lstore.layers {
+		layerPkgs, err := PackagesOnDisk(layer)
+		if err != nil {
+			return pkgs, err
+		}
+		for _, pkg := range layerPkgs {
+			if _, found := seen[pkg]; !found {
+				seen[pkg] = struct{}{}
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// Tarballs returns the tarballs of the package in all layers.
+func (lstore LayeredStore) Tarballs(pkg Package) ([]Tarball, error) {
+	tarballs := []Tarball{}
+	seen := map[Tarball]struct{}{}
+	for _, layer := range lstore.layers {
+		layerTarballs, err := layer.Tarballs(pkg)
+		if err != nil {
+			return tarballs, err
+		}
+		for _, tarball := range layerTarballs {
+			if _, found := seen[tarball]; !found {
+				seen[tarball] = struct{}{}
+				tarballs = append(tarballs, tarball)
+			}
+		}
+	}
+	return tarballs, nil
+}
+
+// Index writes package metadata with the versions from all layers to the top layer.
+func (lstore LayeredStore) Index(pkg Package) (PackageMetadata, error) {
+	return index(lstore, pkg)
+}
+
+func (lstore LayeredStore) QuarantineTarball(tarball Tarball) error {
+	return lstore.change(tarball, lstore.top().QuarantineTarball)
+}
+
+func (lstore LayeredStore) DeleteTarball(tarball Tarball) error {
+	return lstore.change(tarball, lstore.top().DeleteTarball)
+}
+
+// change calls fn if the tarball is in the top layer, returns ErrReadOnly if it's
+// only in a read-only layer.
+func (lstore LayeredStore) change(tarball Tarball, fn func(Tarball) error) error {
+	err := fn(tarball)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if _, err := first(lstore.layers[1:], func(s Store) (TarballInfo, error) {
+		return s.StatTarball(tarball)
+	}); err == nil {
+		return fmt.Errorf("%w: %s", ErrReadOnly, tarball.String())
+	}
+	return ErrNotFound
+}
+
+// DeletePackage removes the package from the top layer, it can't be removed if
+// any read-only layer has tarballs of the package.
+func (lstore LayeredStore) DeletePackage(pkg Package) error {
+	for _, layer := range lstore.layers[1:] {
+		tarballs, err := layer.Tarballs(pkg)
+		if err != nil {
+			return err
+		}
+		if len(tarballs) > 0 {
+			return fmt.Errorf("%w: %s", ErrReadOnly, pkg.String())
+		}
+	}
+	return lstore.top().DeletePackage(pkg)
+}
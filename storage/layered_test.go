@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"enpeeem/storage/storagetest"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLayeredStore(t *testing.T) {
+	baseDir, topDir := t.TempDir(), t.TempDir()
+	base, top := NewFileStore(baseDir, baseDir), NewFileStore(topDir, topDir)
+	x := Package{Registry: "registry.npmjs.org", Name: "x"}
+	y := Package{Registry: "registry.npmjs.org", Name: "y"}
+	tarball := func(pkg Package, version string) (Tarball, []byte) {
+		data := storagetest.NewTarball(t, map[string]string{"package/package.json": fmt.Sprintf(`{"name":%q,"version":%q}`, pkg.Name, version)})
+		return NewTarball(pkg, fmt.Sprintf("%s-%s.tgz", pkg.Name, version)), data
+	}
+	for _, put := range []struct {
+		store   Store
+		pkg     Package
+		version string
+	}{{base, x, "1.0.0"}, {base, y, "1.0.0"}, {top, x, "2.0.0"}} {
+		tb, data := tarball(put.pkg, put.version)
+		if err := put.store.PutTarball(tb, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := base.Index(x); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutDistTags(base, x, map[string]string{"curated": "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewLayeredStore(top, base)
+	pkgs, err := store.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 {
+		t.Errorf("expected 2 packages but got %v", pkgs)
+	}
+	tarballs, err := store.Tarballs(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tarballs) != 2 {
+		t.Errorf("expected 2 tarballs but got %v", tarballs)
+	}
+
+	pkmt, err := store.Index(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := pkmt.Versions["1.0.0"]; !found {
+		t.Error("expected version 1.0.0 from base layer")
+	}
+	if _, found := pkmt.Versions["2.0.0"]; !found {
+		t.Error("expected version 2.0.0 from top layer")
+	}
+	if pkmt.DistTags["curated"] != "1.0.0" {
+		t.Errorf("expected dist-tag from base layer but got %v", pkmt.DistTags)
+	}
+	if _, err := top.GetPackageMetadata(x); err != nil {
+		t.Errorf("expected metadata in top layer but got %v", err)
+	}
+	if basePkmt, _ := base.GetPackageMetadata(x); len(basePkmt.Versions) != 1 {
+		t.Errorf("expected base metadata to be unchanged but got %v", basePkmt.VersionList())
+	}
+
+	baseTarball, _ := tarball(x, "1.0.0")
+	if err := store.DeleteTarball(baseTarball); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v but got %v", ErrReadOnly, err)
+	}
+	if err := store.DeletePackage(x); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v but got %v", ErrReadOnly, err)
+	}
+	topTarball, _ := tarball(x, "2.0.0")
+	if err := store.DeleteTarball(topTarball); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+	if err := store.DeleteTarball(topTarball); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+	}
+}
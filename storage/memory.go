@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryTarball struct {
+	data    []byte
+	modTime time.Time
+}
+
+// MemoryStore keeps everything in memory, for tests and tools embedding enpeeem
+// that shouldn't touch disk. It's safe for concurrent use.
+type MemoryStore struct {
+	mux         sync.RWMutex
+	tarballs    map[Package]map[string]memoryTarball
+	metadata    map[Package][]byte
+	assets      map[Package]map[string][]byte
+	quarantined map[Tarball][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tarballs:    map[Package]map[string]memoryTarball{},
+		metadata:    map[Package][]byte{},
+		assets:      map[Package]map[string][]byte{},
+		quarantined: map[Tarball][]byte{},
+	}
+}
+
+func (mstore *MemoryStore) GetPackageMetadataRaw(pkg Package) ([]byte, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	data, found := mstore.metadata[pkg]
+	if !found {
+		return []byte{}, ErrNotFound
+	}
+	return slices.Clone(data), nil
+}
+
+func (mstore *MemoryStore) GetPackageMetadata(pkg Package) (PackageMetadata, error) {
+	pkmt := PackageMetadata{}
+	raw, err := mstore.GetPackageMetadataRaw(pkg)
+	if err != nil {
+		return pkmt, err
+	}
+	err = json.Unmarshal(raw, &pkmt)
+	return pkmt, err
+}
+
+func (mstore *MemoryStore) PutPackage(pkg Package, data []byte) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	mstore.metadata[pkg] = slices.Clone(data)
+	return nil
+}
+
+func (mstore *MemoryStore) PutTarball(tarball Tarball, data []byte) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	pkg := tarball.Package()
+	if mstore.tarballs[pkg] == nil {
+		mstore.tarballs[pkg] = map[string]memoryTarball{}
+	}
+	mstore.tarballs[pkg][tarball.Name] = memoryTarball{data: slices.Clone(data), modTime: time.Now()}
+	return nil
+}
+
+func (mstore *MemoryStore) GetTarball(tarball Tarball) ([]byte, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	t, found := mstore.tarballs[tarball.Package()][tarball.Name]
+	if !found {
+		return []byte{}, ErrNotFound
+	}
+	return slices.Clone(t.data), nil
+}
+
+func (mstore *MemoryStore) StatTarball(tarball Tarball) (TarballInfo, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	t, found := mstore.tarballs[tarball.Package()][tarball.Name]
+	if !found {
+		return TarballInfo{}, ErrNotFound
+	}
+	return TarballInfo{Size: int64(len(t.data)), ModTime: t.modTime}, nil
+}
+
+// Packages returns all packages with tarballs, metadata or assets.
+func (mstore *MemoryStore) Packages() ([]Package, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	found := map[Package]bool{}
+	for pkg := range mstore.tarballs {
+		found[pkg] = true
+	}
+	for pkg := range mstore.metadata {
+		found[pkg] = true
+	}
+	for pkg := range mstore.assets {
+		found[pkg] = true
+	}
+	pkgs := []Package{}
+	for pkg := range found {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].String() < pkgs[j].String()
+	})
+	return pkgs, nil
+}
+
+// Tarballs returns the tarballs of a package sorted by name.
+func (mstore *MemoryStore) Tarballs(pkg Package) ([]Tarball, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	tarballs := []Tarball{}
+	for name := range mstore.tarballs[pkg] {
+		tarballs = append(tarballs, NewTarball(pkg, name))
+	}
+	sort.Slice(tarballs, func(i, j int) bool {
+		return tarballs[i].Name < tarballs[j].Name
+	})
+	return tarballs, nil
+}
+
+func (mstore *MemoryStore) Index(pkg Package) (PackageMetadata, error) {
+	return index(mstore, pkg)
+}
+
+// QuarantineTarball removes the tarball but keeps it's data for inspection.
+func (mstore *MemoryStore) QuarantineTarball(tarball Tarball) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	t, found := mstore.tarballs[tarball.Package()][tarball.Name]
+	if !found {
+		return ErrNotFound
+	}
+	mstore.quarantined[tarball] = t.data
+	delete(mstore.tarballs[tarball.Package()], tarball.Name)
+	return nil
+}
+
+func (mstore *MemoryStore) GetPackageAsset(pkg Package, name string) ([]byte, error) {
+	mstore.mux.RLock()
+	defer mstore.mux.RUnlock()
+	data, found := mstore.assets[pkg][name]
+	if !found {
+		return []byte{}, ErrNotFound
+	}
+	return slices.Clone(data), nil
+}
+
+func (mstore *MemoryStore) PutPackageAsset(pkg Package, name string, data []byte) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	if mstore.assets[pkg] == nil {
+		mstore.assets[pkg] = map[string][]byte{}
+	}
+	mstore.assets[pkg][name] = slices.Clone(data)
+	return nil
+}
+
+func (mstore *MemoryStore) DeleteTarball(tarball Tarball) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	if _, found := mstore.tarballs[tarball.Package()][tarball.Name]; !found {
+		return ErrNotFound
+	}
+	delete(mstore.tarballs[tarball.Package()], tarball.Name)
+	return nil
+}
+
+// DeletePackage removes all tarballs, metadata and assets for a package.
+func (mstore *MemoryStore) DeletePackage(pkg Package) error {
+	mstore.mux.Lock()
+	defer mstore.mux.Unlock()
+	delete(mstore.tarballs, pkg)
+	delete(mstore.metadata, pkg)
+	delete(mstore.assets, pkg)
+	return nil
+}
@@ -37,6 +37,14 @@ func (pkg Package) String() string {
 	}
 }
 
+// FullName returns the package name as used by npm, including the scope if any.
+func (pkg Package) FullName() string {
+	if pkg.Scope == "" {
+		return pkg.Name
+	}
+	return pkg.Scope + "/" + pkg.Name
+}
+
 // PackageMetadataFromURI parses an uri and returns a Tarball object without data. For example registry.npmjs.org/@babel/parser/parser-7.24.0.tgz.
 func PackageMetadataFromURI(uri string) (Package, error) {
 	pkmt := Package{}
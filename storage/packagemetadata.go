@@ -3,8 +3,7 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"path"
 	"slices"
 	"sort"
 	"text/template"
@@ -15,6 +14,7 @@ import (
 type PackageMetadata struct {
 	DistTags map[string]string      `json:"dist-tags"`
 	Name     string                 `json:"name"`
+	Time     map[string]string      `json:"time,omitempty"`
 	Versions map[string]interface{} `json:"versions"`
 }
 
@@ -58,12 +58,83 @@ func (pm *PackageMetadata) SetLatestVersion() {
 	pm.DistTags["latest"] = latestStableVersion(versions)
 }
 
+// Integrity returns the subresource integrity string for a version from its dist
+// field. If the version only has a shasum it's converted to an integrity string.
+// An empty string is returned if the version has no dist information.
+func (pm PackageMetadata) Integrity(version string) string {
+	ver, _ := pm.Versions[version].(map[string]interface{})
+	dist, _ := ver["dist"].(map[string]interface{})
+	if integrity, _ := dist["integrity"].(string); integrity != "" {
+		return integrity
+	}
+	if shasum, _ := dist["shasum"].(string); shasum != "" {
+		return ShasumIntegrity(shasum)
+	}
+	return ""
+}
+
+// Dist returns the hashes from the dist field of a version.
+func (pm PackageMetadata) Dist(version string) Dist {
+	ver, _ := pm.Versions[version].(map[string]interface{})
+	dist, _ := ver["dist"].(map[string]interface{})
+	integrity, _ := dist["integrity"].(string)
+	shasum, _ := dist["shasum"].(string)
+	return Dist{Integrity: integrity, Shasum: shasum}
+}
+
+// TarballName returns the file name of the tarball URL of a version, an empty
+// string if the version has no tarball URL.
+func (pm PackageMetadata) TarballName(version string) string {
+	ver, _ := pm.Versions[version].(map[string]interface{})
+	dist, _ := ver["dist"].(map[string]interface{})
+	tarballURL, _ := dist["tarball"].(string)
+	if tarballURL == "" {
+		return ""
+	}
+	return path.Base(tarballURL)
+}
+
+// ResolveVersion returns the version matching spec, which is a version, a
+// dist-tag or a semver range. Ranges resolve to the highest matching version,
+// an empty spec to the latest dist-tag. ErrNotFound is returned if no version
+// matches.
+func (pm PackageMetadata) ResolveVersion(spec string) (string, error) {
+	if spec == "" {
+		spec = "latest"
+	}
+	if _, found := pm.Versions[spec]; found {
+		return spec, nil
+	}
+	if version, found := pm.DistTags[spec]; found {
+		if _, found := pm.Versions[version]; found {
+			return version, nil
+		}
+	}
+	c, err := semver.NewConstraint(spec)
+	if err != nil {
+		return "", fmt.Errorf("version %s of %s: %w", spec, pm.Name, ErrNotFound)
+	}
+	var best *semver.Version
+	for _, v := range pm.VersionList() {
+		sv, err := semver.NewVersion(v)
+		if err != nil || !c.Check(sv) {
+			continue
+		}
+		if best == nil || sv.GreaterThan(best) {
+			best = sv
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("version %s of %s: %w", spec, pm.Name, ErrNotFound)
+	}
+	return best.Original(), nil
+}
+
 func (pm *PackageMetadata) RewriteURLs(tmpl *template.Template) error {
 	newvers := map[string]interface{}{}
-	fmt.Println(len(pm.Versions))
 	for k, v := range pm.Versions {
 		version := v.(map[string]interface{})
-		dist := version["dist"].(map[string]interface{})
+		dist, _ := version["dist"].(map[string]interface{})
 		tbl, err := TarballFromURI(dist["tarball"].(string))
 		if err != nil {
 			return err
@@ -72,7 +143,11 @@ func (pm *PackageMetadata) RewriteURLs(tmpl *template.Template) error {
 		if err != nil {
 			return err
 		}
-		v.(map[string]interface{})["dist"] = map[string]string{"tarball": nurl}
+		if dist == nil {
+			dist = map[string]interface{}{}
+		}
+		dist["tarball"] = nurl
+		version["dist"] = dist
 		newvers[k] = v
 	}
 
@@ -80,6 +155,25 @@ func (pm *PackageMetadata) RewriteURLs(tmpl *template.Template) error {
 	return nil
 }
 
+// HasInstallScript returns true if version metadata, or package.json, has
+// lifecycle scripts run when the package is installed.
+func HasInstallScript(meta map[string]interface{}) bool {
+	if has, _ := meta["hasInstallScript"].(bool); has {
+		return true
+	}
+	// npm sets gypfile for packages with a binding.gyp and runs node-gyp for them
+	if gyp, _ := meta["gypfile"].(bool); gyp {
+		return true
+	}
+	scripts, _ := meta["scripts"].(map[string]interface{})
+	for _, script := range []string{"preinstall", "install", "postinstall"} {
+		if _, found := scripts[script]; found {
+			return true
+		}
+	}
+	return false
+}
+
 // ParsePackageJson unpacks and parses package.json metadata from raw tarball bytes. It
 // returns the semantic version name, the raw json map or an error if something failed.
 func (pm *PackageMetadata) ParsePackageJson(tarball Tarball, data []byte) (string, map[string]interface{}, error) {
@@ -87,7 +181,35 @@ func (pm *PackageMetadata) ParsePackageJson(tarball Tarball, data []byte) (strin
 	if err != nil {
 		return "", nil, fmt.Errorf("could not fetch package.json from tarball: %w", err)
 	}
-	return parsePackageJson(tarball, pkgJson)
+	verNo, raw, err := parsePackageJson(tarball, pkgJson)
+	if err != nil {
+		return verNo, raw, err
+	}
+	raw["dist"] = map[string]interface{}{
+		"tarball":   tarball.RemoteURL(),
+		"integrity": Integrity(data),
+		"shasum":    Shasum(data),
+	}
+	if err := DetectInstallScript(tarball, data, raw); err != nil {
+		return verNo, raw, err
+	}
+	return verNo, raw, nil
+}
+
+// DetectInstallScript sets hasInstallScript in package.json metadata, true if the
+// package has lifecycle scripts run on install or a binding.gyp file, npm runs
+// node-gyp when installing those unless gypfile is false. It's set to false
+// otherwise so reindexing can tell versions that were already checked.
+func DetectInstallScript(tarball Tarball, data []byte, meta map[string]interface{}) error {
+	hasScript := HasInstallScript(meta)
+	if gyp, found := meta["gypfile"].(bool); !hasScript && (!found || gyp) {
+		var err error
+		if hasScript, err = tarball.ContainsFile(data, "*/binding.gyp"); err != nil {
+			return err
+		}
+	}
+	meta["hasInstallScript"] = hasScript
+	return nil
 }
 
 func parsePackageJson(tarball Tarball, data []byte) (string, map[string]interface{}, error) {
@@ -130,18 +252,6 @@ func latestStableVersion(versions []string) string {
 
 // FetchPackageMetadataRemotely downloads package metadata from remote registry,
 func FetchPackageMetadataRemotely(pkg Package) ([]byte, error) {
-	resp, err := http.Get(pkg.RemoteURL())
-	if err != nil {
-		return []byte{}, err
-	}
-
-	switch resp.StatusCode {
-	case http.StatusNotFound:
-		return []byte{}, ErrNotFound
-	case http.StatusOK:
-		defer resp.Body.Close()
-		return io.ReadAll(resp.Body)
-	default:
-		return []byte{}, fmt.Errorf("error calling %s responded with: %v %s", pkg.RemoteURL(), resp.StatusCode, resp.Status)
-	}
+	data, _, err := FetchPackageMetadataFallback(pkg)
+	return data, err
 }
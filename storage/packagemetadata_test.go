@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"enpeeem/storage/storagetest"
+	"errors"
 	"slices"
 	"testing"
 )
@@ -34,6 +36,41 @@ func TestLatestStableVersion(t *testing.T) {
 
 }
 
+func TestResolveVersion(t *testing.T) {
+	pkmt := NewPackageMetadata("2.1.0", "x", map[string]interface{}{
+		"1.0.0":        "",
+		"1.2.3":        "",
+		"2.1.0":        "",
+		"3.0.0-beta.1": "",
+	})
+	pkmt.DistTags["next"] = "3.0.0-beta.1"
+	type Test struct {
+		Spec     string
+		Expected string
+		Err      error
+	}
+	tests := []Test{
+		{"", "2.1.0", nil},
+		{"1.0.0", "1.0.0", nil},
+		{"next", "3.0.0-beta.1", nil},
+		{"^1", "1.2.3", nil},
+		{"1.x", "1.2.3", nil},
+		{">=2", "2.1.0", nil},
+		{"^3.0.0-0", "3.0.0-beta.1", nil},
+		{"^4", "", ErrNotFound},
+		{"beta", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		actual, err := pkmt.ResolveVersion(test.Spec)
+		if !errors.Is(err, test.Err) {
+			t.Errorf("%s: expected %v but got %v", test.Spec, test.Err, err)
+		}
+		if actual != test.Expected {
+			t.Errorf("%s: expected %s but got %s", test.Spec, test.Expected, actual)
+		}
+	}
+}
+
 func TestPruneVersions(t *testing.T) {
 	pkg := Package{Registry: "registry.npmjs.org", Scope: "", Name: "create-vite"}
 	type Test struct {
@@ -69,3 +106,55 @@ func TestPruneVersions(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectInstallScript(t *testing.T) {
+	pkg := Package{Registry: "registry.npmjs.org", Scope: "", Name: "create-vite"}
+	tarball := NewTarball(pkg, "create-vite-5.0.0.tgz")
+	type Test struct {
+		Files    map[string]string
+		Expected bool
+	}
+	tests := []Test{
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0"}`}, Expected: false},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0","scripts":{"test":"jest"}}`}, Expected: false},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0","scripts":{"postinstall":"node install.js"}}`}, Expected: true},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0"}`, "package/binding.gyp": "{}"}, Expected: true},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0"}`, "package/deps/binding.gyp": "{}"}, Expected: false},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0","gypfile":false}`, "package/binding.gyp": "{}"}, Expected: false},
+		{Files: map[string]string{"package/package.json": `{"version":"5.0.0","gypfile":true}`}, Expected: true},
+	}
+
+	for _, test := range tests {
+		pkmt := PackageMetadata{}
+		_, meta, err := pkmt.ParsePackageJson(tarball, storagetest.NewTarball(t, test.Files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, _ := meta["hasInstallScript"].(bool)
+		if actual != test.Expected {
+			t.Errorf("%v: expected hasInstallScript %v but got %v", test.Files, test.Expected, actual)
+		}
+	}
+}
+
+func TestIndexDetectsInstallScript(t *testing.T) {
+	store := NewMemoryStore()
+	pkg := Package{Registry: "registry.npmjs.org", Name: "create-vite"}
+	tarball := NewTarball(pkg, "create-vite-5.0.0.tgz")
+	data := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"version":"5.0.0"}`, "package/binding.gyp": "{}"})
+	if err := store.PutTarball(tarball, data); err != nil {
+		t.Fatal(err)
+	}
+	// metadata indexed before install scripts were detected
+	if err := store.PutPackage(pkg, []byte(`{"name":"create-vite","dist-tags":{"latest":"5.0.0"},"versions":{"5.0.0":{"version":"5.0.0"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	pkmt, err := store.Index(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, _ := pkmt.Versions["5.0.0"].(map[string]interface{})
+	if has, _ := version["hasInstallScript"].(bool); !has {
+		t.Errorf("expected hasInstallScript to be backfilled but got %v", version)
+	}
+}
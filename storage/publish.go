@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"slices"
+)
+
+// GetPublished returns versions of a package published directly to enpeeem.
+func GetPublished(store Store, pkg Package) ([]string, error) {
+	published := []string{}
+	err := GetJSONAsset(store, pkg, PublishedAssetName, &published)
+	return published, err
+}
+
+// AddPublished records a version as published directly to enpeeem.
+func AddPublished(store Store, pkg Package, version string) error {
+	published, err := GetPublished(store, pkg)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(published, version) {
+		return nil
+	}
+	return PutJSONAsset(store, pkg, PublishedAssetName, append(published, version))
+}
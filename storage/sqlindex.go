@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS packages (
+	registry    TEXT NOT NULL,
+	scope       TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	latest      TEXT NOT NULL DEFAULT '',
+	modified    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (registry, scope, name)
+);
+CREATE TABLE IF NOT EXISTS versions (
+	registry  TEXT NOT NULL,
+	scope     TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	version   TEXT NOT NULL,
+	tarball   TEXT NOT NULL,
+	integrity TEXT NOT NULL,
+	size      INTEGER NOT NULL,
+	published TEXT NOT NULL,
+	stashed   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (registry, scope, name, version)
+);
+CREATE INDEX IF NOT EXISTS versions_tarball ON versions (registry, scope, name, tarball);
+CREATE INDEX IF NOT EXISTS versions_integrity ON versions (integrity);
+CREATE TABLE IF NOT EXISTS dist_tags (
+	registry TEXT NOT NULL,
+	scope    TEXT NOT NULL,
+	name     TEXT NOT NULL,
+	tag      TEXT NOT NULL,
+	version  TEXT NOT NULL,
+	PRIMARY KEY (registry, scope, name, tag)
+);
+`
+
+// indexes are created after the migrations adding their columns.
+const indexes = `
+CREATE INDEX IF NOT EXISTS versions_stashed ON versions (stashed);
+`
+
+// SQLIndex is an SQLite database of the packages, versions and dist-tags in a
+// file store, so listing packages and queries over the whole stash don't read
+// every metadata file. It's kept up to date by the file store and can be rebuilt
+// from disk.
+type SQLIndex struct {
+	db *sql.DB
+}
+
+// IndexedVersion is a stashed version of a package.
+type IndexedVersion struct {
+	Version   string `json:"version"`
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+	Size      int64  `json:"size"`
+	Published string `json:"published,omitempty"`
+}
+
+// StashedVersion is a version with the modification time of its tarball.
+type StashedVersion struct {
+	Package
+	Version string
+	Size    int64
+	Stashed time.Time
+}
+
+// IndexedPackage is a package with the description and version of its latest
+// dist-tag.
+type IndexedPackage struct {
+	Package
+	Description string
+	Latest      string
+	Modified    string
+}
+
+// RegistryStats is the number of packages, versions and size of the tarballs
+// stashed for a registry.
+type RegistryStats struct {
+	Registry string `json:"registry"`
+	Packages int    `json:"packages"`
+	Versions int    `json:"versions"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Stats is the size of the stash per registry. UniqueBytes is the size of all
+// distinct tarballs, the size with deduplicated tarballs.
+type Stats struct {
+	Registries  []RegistryStats `json:"registries"`
+	Bytes       int64           `json:"bytes"`
+	UniqueBytes int64           `json:"uniqueBytes"`
+}
+
+// OpenSQLIndex opens or creates the index database file.
+func OpenSQLIndex(file string) (*SQLIndex, error) {
+	db, err := sql.Open("sqlite", "file:"+file+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writes, sqlite allows only one writer anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sql index schema in %s: %w", file, err)
+	}
+	if err := migrateSQLIndex(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating sql index %s: %w", file, err)
+	}
+	if _, err := db.Exec(indexes); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sql index schema in %s: %w", file, err)
+	}
+	return &SQLIndex{db: db}, nil
+}
+
+// migrateSQLIndex adds the stashed column to an index created without it. The index is
+// emptied so it's rebuilt with the stashed times on startup.
+func migrateSQLIndex(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('versions') WHERE name = 'stashed'").Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{"ALTER TABLE versions ADD COLUMN stashed INTEGER NOT NULL DEFAULT 0", "DELETE FROM packages", "DELETE FROM versions", "DELETE FROM dist_tags"} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (idx *SQLIndex) Close() error {
+	return idx.db.Close()
+}
+
+// Empty returns true if the index has no packages, for example when it was just
+// created.
+func (idx *SQLIndex) Empty() (bool, error) {
+	var n int
+	err := idx.db.QueryRow("SELECT COUNT(*) FROM packages").Scan(&n)
+	return n == 0, err
+}
+
+// AddPackage adds a package without versions if it's not in the index.
+func (idx *SQLIndex) AddPackage(pkg Package) error {
+	_, err := idx.db.Exec("INSERT OR IGNORE INTO packages (registry, scope, name) VALUES (?, ?, ?)", pkg.Registry, pkg.Scope, pkg.Name)
+	return err
+}
+
+// PutPackage replaces the versions and dist-tags of a package with the ones in the
+// package metadata, stat returns the size and modification time of a tarball file.
+func (idx *SQLIndex) PutPackage(pkg Package, pkmt PackageMetadata, stat func(tarball string) TarballInfo) error {
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := putPackage(tx, pkg, pkmt, stat); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func putPackage(tx *sql.Tx, pkg Package, pkmt PackageMetadata, stat func(tarball string) TarballInfo) error {
+	if err := deletePackage(tx, pkg); err != nil {
+		return err
+	}
+	latest := pkmt.DistTags["latest"]
+	version, _ := pkmt.Versions[latest].(map[string]interface{})
+	description, _ := version["description"].(string)
+	if _, err := tx.Exec("INSERT INTO packages (registry, scope, name, description, latest, modified) VALUES (?, ?, ?, ?, ?, ?)",
+		pkg.Registry, pkg.Scope, pkg.Name, description, latest, pkmt.Time["modified"]); err != nil {
+		return err
+	}
+	for v, meta := range pkmt.Versions {
+		version, _ := meta.(map[string]interface{})
+		dist, _ := version["dist"].(map[string]interface{})
+		tarballURL, _ := dist["tarball"].(string)
+		tarball := path.Base(tarballURL)
+		info := stat(tarball)
+		if _, err := tx.Exec("INSERT INTO versions (registry, scope, name, version, tarball, integrity, size, published, stashed) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			pkg.Registry, pkg.Scope, pkg.Name, v, tarball, pkmt.Integrity(v), info.Size, pkmt.Time[v], unixNano(info.ModTime)); err != nil {
+			return err
+		}
+	}
+	for tag, v := range pkmt.DistTags {
+		if _, err := tx.Exec("INSERT INTO dist_tags (registry, scope, name, tag, version) VALUES (?, ?, ?, ?, ?)",
+			pkg.Registry, pkg.Scope, pkg.Name, tag, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTarballInfo updates the size and stashed time of an indexed tarball that was
+// written again.
+func (idx *SQLIndex) SetTarballInfo(tarball Tarball, info TarballInfo) error {
+	pkg := tarball.Package()
+	_, err := idx.db.Exec("UPDATE versions SET size = ?, stashed = ? WHERE registry = ? AND scope = ? AND name = ? AND tarball = ?",
+		info.Size, unixNano(info.ModTime), pkg.Registry, pkg.Scope, pkg.Name, tarball.Name)
+	return err
+}
+
+// unixNano returns t in nanoseconds, 0 for the zero time of a missing tarball.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// DeleteTarball removes the version of a tarball.
+func (idx *SQLIndex) DeleteTarball(tarball Tarball) error {
+	pkg := tarball.Package()
+	_, err := idx.db.Exec("DELETE FROM versions WHERE registry = ? AND scope = ? AND name = ? AND tarball = ?",
+		pkg.Registry, pkg.Scope, pkg.Name, tarball.Name)
+	return err
+}
+
+// DeletePackage removes a package with all its versions and dist-tags.
+func (idx *SQLIndex) DeletePackage(pkg Package) error {
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deletePackage(tx, pkg); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deletePackage(tx *sql.Tx, pkg Package) error {
+	for _, table := range []string{"packages", "versions", "dist_tags"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE registry = ? AND scope = ? AND name = ?", pkg.Registry, pkg.Scope, pkg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild replaces everything in the index with the given packages, load returns
+// the package metadata and tarball infos of a package. found is false for packages
+// that are not indexed yet.
+func (idx *SQLIndex) Rebuild(pkgs []Package, load func(Package) (pkmt PackageMetadata, stat func(string) TarballInfo, found bool, err error)) error {
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"packages", "versions", "dist_tags"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	for _, pkg := range pkgs {
+		pkmt, stat, found, err := load(pkg)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %w", pkg.String(), err)
+		}
+		if !found {
+			if _, err := tx.Exec("INSERT INTO packages (registry, scope, name) VALUES (?, ?, ?)", pkg.Registry, pkg.Scope, pkg.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := putPackage(tx, pkg, pkmt, stat); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Packages returns all packages sorted by registry and name.
+func (idx *SQLIndex) Packages() ([]Package, error) {
+	pkgs := []Package{}
+	rows, err := idx.db.Query("SELECT registry, scope, name FROM packages ORDER BY registry, scope, name")
+	if err != nil {
+		return pkgs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pkg := Package{}
+		if err := rows.Scan(&pkg.Registry, &pkg.Scope, &pkg.Name); err != nil {
+			return pkgs, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, rows.Err()
+}
+
+// Versions returns the stashed versions of a package sorted by publish time.
+func (idx *SQLIndex) Versions(pkg Package) ([]IndexedVersion, error) {
+	versions := []IndexedVersion{}
+	rows, err := idx.db.Query("SELECT version, tarball, integrity, size, published FROM versions WHERE registry = ? AND scope = ? AND name = ? ORDER BY published, version",
+		pkg.Registry, pkg.Scope, pkg.Name)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := IndexedVersion{}
+		if err := rows.Scan(&v.Version, &v.Tarball, &v.Integrity, &v.Size, &v.Published); err != nil {
+			return versions, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Search returns packages of a registry, or of all registries if registry is
+// empty, with text in the name or description. The package with the exact name
+// comes first and then packages with the text in the name. The total number of
+// matches is returned with the packages from offset from.
+func (idx *SQLIndex) Search(registry, text string, from, size int) ([]IndexedPackage, int, error) {
+	pkgs := []IndexedPackage{}
+	like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(text)) + "%"
+	fullName := "lower(CASE scope WHEN '' THEN name ELSE scope || '/' || name END)"
+	where := "(? = '' OR registry = ?) AND (" + fullName + ` LIKE ? ESCAPE '\' OR lower(description) LIKE ? ESCAPE '\')`
+	total := 0
+	if err := idx.db.QueryRow("SELECT COUNT(*) FROM packages WHERE "+where, registry, registry, like, like).Scan(&total); err != nil {
+		return pkgs, 0, err
+	}
+	rows, err := idx.db.Query("SELECT registry, scope, name, description, latest, modified FROM packages WHERE "+where+
+		" ORDER BY "+fullName+" != ?, "+fullName+` NOT LIKE ? ESCAPE '\', `+fullName+", registry LIMIT ? OFFSET ?", registry, registry, like, like, strings.ToLower(text), like, size, from)
+	if err != nil {
+		return pkgs, total, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pkg := IndexedPackage{}
+		if err := rows.Scan(&pkg.Registry, &pkg.Scope, &pkg.Name, &pkg.Description, &pkg.Latest, &pkg.Modified); err != nil {
+			return pkgs, total, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, total, rows.Err()
+}
+
+// Recent returns the n versions with the most recently stashed tarballs.
+func (idx *SQLIndex) Recent(n int) ([]StashedVersion, error) {
+	versions := []StashedVersion{}
+	rows, err := idx.db.Query("SELECT registry, scope, name, version, size, stashed FROM versions WHERE stashed > 0 ORDER BY stashed DESC LIMIT ?", n)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := StashedVersion{}
+		var stashed int64
+		if err := rows.Scan(&v.Registry, &v.Scope, &v.Name, &v.Version, &v.Size, &stashed); err != nil {
+			return versions, err
+		}
+		v.Stashed = time.Unix(0, stashed)
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Stats returns the number of packages and versions and the size of the stash.
+func (idx *SQLIndex) Stats() (Stats, error) {
+	stats := Stats{Registries: []RegistryStats{}}
+	rows, err := idx.db.Query(`SELECT p.registry, COUNT(DISTINCT p.scope || '/' || p.name), COUNT(v.version), COALESCE(SUM(v.size), 0)
+		FROM packages p LEFT JOIN versions v ON v.registry = p.registry AND v.scope = p.scope AND v.name = p.name
+		GROUP BY p.registry ORDER BY p.registry`)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rs := RegistryStats{}
+		if err := rows.Scan(&rs.Registry, &rs.Packages, &rs.Versions, &rs.Bytes); err != nil {
+			return stats, err
+		}
+		stats.Registries = append(stats.Registries, rs)
+		stats.Bytes += rs.Bytes
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+	// tarballs without integrity can't be matched and are counted as distinct
+	err = idx.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM (
+		SELECT MAX(size) AS size FROM versions WHERE integrity != '' GROUP BY integrity
+		UNION ALL SELECT size FROM versions WHERE integrity = '')`).Scan(&stats.UniqueBytes)
+	return stats, err
+}
+
+// WithSQLIndex returns the file store keeping the SQL index up to date and
+// listing packages from it.
+func (fstore FileStore) WithSQLIndex(idx *SQLIndex) *FileStore {
+	fstore.sql = idx
+	return &fstore
+}
+
+// updateSQLIndex changes the SQL index after the files were changed, a failure is
+// logged since the files are the source of truth and the index can be rebuilt.
+func (fstore FileStore) updateSQLIndex(update func(idx *SQLIndex) error) {
+	if fstore.sql == nil {
+		return
+	}
+	if err := update(fstore.sql); err != nil {
+		slog.Error("failed to update sql index, rebuild it with flag sqlindex-rebuild", "cause", err)
+	}
+}
+
+// tarballInfo returns the size and modification time of the tarball files of a
+// package, zero if missing.
+func (fstore FileStore) tarballInfo(pkg Package) func(string) TarballInfo {
+	return func(name string) TarballInfo {
+		info, err := os.Stat(path.Join(fstore.tarballDir(pkg), name))
+		if err != nil {
+			return TarballInfo{}
+		}
+		return TarballInfo{Size: info.Size(), ModTime: info.ModTime()}
+	}
+}
+
+// RebuildSQLIndex replaces the SQL index with the packages and metadata on disk.
+func (fstore FileStore) RebuildSQLIndex() error {
+	if fstore.sql == nil {
+		return fmt.Errorf("sql index is not enabled")
+	}
+	pkgs, err := fstore.PackagesOnDisk()
+	if err != nil {
+		return err
+	}
+	return fstore.sql.Rebuild(pkgs, func(pkg Package) (PackageMetadata, func(string) TarballInfo, bool, error) {
+		pkmt, err := fstore.GetPackageMetadata(pkg)
+		if errors.Is(err, ErrNotFound) {
+			return pkmt, nil, false, nil
+		}
+		return pkmt, fstore.tarballInfo(pkg), err == nil, err
+	})
+}
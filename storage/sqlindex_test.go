@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"enpeeem/storage/storagetest"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSQLIndex(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := OpenSQLIndex(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	store := NewFileStore(dir, dir).WithSQLIndex(idx)
+
+	react := Package{Registry: "registry.npmjs.org", Name: "react"}
+	types := Package{Registry: "registry.npmjs.org", Scope: "@types", Name: "react"}
+	mirrored := Package{Registry: "mirror.example.com", Name: "react"}
+	sizes := map[string]int64{}
+	for _, put := range []struct {
+		pkg     Package
+		version string
+	}{{react, "18.0.0"}, {react, "18.1.0"}, {types, "18.0.0"}, {mirrored, "18.0.0"}} {
+		data := storagetest.NewTarball(t, map[string]string{"package/package.json": fmt.Sprintf(`{"name":%q,"version":%q,"description":"a %s library"}`, put.pkg.FullName(), put.version, put.pkg.Name)})
+		if put.pkg == mirrored {
+			// same tarball as in the npm registry
+			data, _ = store.GetTarball(NewTarball(react, "react-18.0.0.tgz"))
+		}
+		if err := store.PutTarball(NewTarball(put.pkg, fmt.Sprintf("%s-%s.tgz", put.pkg.Name, put.version)), data); err != nil {
+			t.Fatal(err)
+		}
+		sizes[put.pkg.String()] += int64(len(data))
+	}
+	for _, pkg := range []Package{react, types, mirrored} {
+		if _, err := store.Index(pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := idx.Versions(react)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Tarball != "react-18.0.0.tgz" || versions[0].Integrity == "" || versions[0].Size == 0 || versions[0].Published == "" {
+		t.Errorf("expected 2 versions with tarball, integrity, size and time but got %+v", versions)
+	}
+
+	stats, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RegistryStats{
+		{Registry: "mirror.example.com", Packages: 1, Versions: 1, Bytes: sizes[mirrored.String()]},
+		{Registry: "registry.npmjs.org", Packages: 2, Versions: 3, Bytes: sizes[react.String()] + sizes[types.String()]},
+	}
+	if !slices.Equal(stats.Registries, expected) {
+		t.Errorf("expected %+v but got %+v", expected, stats.Registries)
+	}
+	if stats.UniqueBytes != stats.Bytes-sizes[mirrored.String()] {
+		t.Errorf("expected %d unique bytes but got %d", stats.Bytes-sizes[mirrored.String()], stats.UniqueBytes)
+	}
+	// the same stats without the sql index
+	if fileStats, err := GetStats(NewFileStore(dir, dir)); err != nil || !reflect.DeepEqual(fileStats, stats) {
+		t.Errorf("expected %+v but got %+v %v", stats, fileStats, err)
+	}
+
+	type Test struct {
+		Text     string
+		Expected []string
+	}
+	tests := []Test{
+		{Text: "react", Expected: []string{"react", "@types/react"}},
+		{Text: "@TYPES", Expected: []string{"@types/react"}},
+		{Text: "library", Expected: []string{"@types/react", "react"}},
+		{Text: "%", Expected: []string{}},
+	}
+	for _, test := range tests {
+		pkgs, total, err := idx.Search("registry.npmjs.org", test.Text, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, pkg := range pkgs {
+			names = append(names, pkg.FullName())
+		}
+		if !slices.Equal(names, test.Expected) || total != len(test.Expected) {
+			t.Errorf("%s: expected %v but got %v (total %d)", test.Text, test.Expected, names, total)
+		}
+	}
+
+	// without a registry packages of all registries are found
+	found, total, err := idx.Search("", "react", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(found) != 2 || found[0].Package != mirrored || found[1].Package != react {
+		t.Errorf("expected %v of 3 packages but got %+v of %d", []Package{mirrored, react}, found, total)
+	}
+
+	recent, err := idx.Recent(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Stashed.IsZero() || recent[0].Stashed.Before(recent[1].Stashed) || recent[0].Size == 0 {
+		t.Errorf("expected 2 recently stashed versions but got %+v", recent)
+	}
+
+	if err := store.DeleteTarball(NewTarball(react, "react-18.1.0.tgz")); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := idx.Versions(react); len(versions) != 1 {
+		t.Errorf("expected 1 version after deleting a tarball but got %+v", versions)
+	}
+
+	// a rebuild finds the packages on disk
+	if err := idx.DeletePackage(types); err != nil {
+		t.Fatal(err)
+	}
+	// packages missing from the index are listed for indexing all packages
+	if pkgs, err := PackagesOnDisk(NewLayeredStore(store)); err != nil || !slices.Contains(pkgs, types) {
+		t.Errorf("expected %v on disk but got %v %v", types, pkgs, err)
+	}
+	if err := store.RebuildSQLIndex(); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := store.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(pkgs, []Package{mirrored, react, types}) {
+		t.Errorf("expected %v but got %v", []Package{mirrored, react, types}, pkgs)
+	}
+}
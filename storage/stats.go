@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+)
+
+// GetStats returns the stats of a store by reading the metadata of every package,
+// the SQL index returns the same stats much faster. Tarballs are matched by the
+// integrity in the package metadata, unindexed tarballs are counted as distinct.
+func GetStats(store Store) (Stats, error) {
+	return WalkStats(store, nil)
+}
+
+// WalkStats returns the stats like GetStats and calls visit, if not nil, for every
+// tarball counted so callers can collect more without reading the store again.
+func WalkStats(store Store, visit func(Tarball, TarballInfo)) (Stats, error) {
+	stats := Stats{Registries: []RegistryStats{}}
+	pkgs, err := store.Packages()
+	if err != nil {
+		return stats, err
+	}
+	registries := map[string]*RegistryStats{}
+	unique := map[string]bool{}
+	for _, pkg := range pkgs {
+		tarballs, err := store.Tarballs(pkg)
+		if err != nil {
+			return stats, err
+		}
+		pkmt, err := store.GetPackageMetadata(pkg)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return stats, err
+		}
+		rs, found := registries[pkg.Registry]
+		if !found {
+			rs = &RegistryStats{Registry: pkg.Registry}
+			registries[pkg.Registry] = rs
+		}
+		rs.Packages++
+		for _, tarball := range tarballs {
+			info, err := store.StatTarball(tarball)
+			if err != nil {
+				return stats, err
+			}
+			rs.Versions++
+			rs.Bytes += info.Size
+			stats.Bytes += info.Size
+			integrity := pkmt.Integrity(tarball.Version())
+			if integrity == "" || !unique[integrity] {
+				stats.UniqueBytes += info.Size
+			}
+			if integrity != "" {
+				unique[integrity] = true
+			}
+			if visit != nil {
+				visit(tarball, info)
+			}
+		}
+	}
+	for _, rs := range registries {
+		stats.Registries = append(stats.Registries, *rs)
+	}
+	sort.Slice(stats.Registries, func(i, j int) bool {
+		return stats.Registries[i].Registry < stats.Registries[j].Registry
+	})
+	return stats, nil
+}
@@ -1,13 +1,20 @@
 package storage
 
 import (
+	"encoding/json"
 	"errors"
+	"time"
 )
 
 var (
 	ErrNotFound = errors.New("object not found")
 )
 
+type TarballInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
 type Store interface {
 	GetPackageMetadata(Package) (PackageMetadata, error)
 	GetPackageMetadataRaw(Package) ([]byte, error)
@@ -16,5 +23,50 @@ type Store interface {
 	Packages() ([]Package, error)
 	Tarballs(Package) ([]Tarball, error)
 	GetTarball(Tarball) ([]byte, error)
+	StatTarball(Tarball) (TarballInfo, error)
 	Index(Package) (PackageMetadata, error)
+	QuarantineTarball(Tarball) error
+	GetPackageAsset(Package, string) ([]byte, error)
+	PutPackageAsset(Package, string, []byte) error
+	DeleteTarball(Tarball) error
+	DeletePackage(Package) error
+}
+
+// diskLister is implemented by stores that list packages from an index which may
+// drift from the packages on disk.
+type diskLister interface {
+	PackagesOnDisk() ([]Package, error)
+}
+
+// PackagesOnDisk returns the packages in the store's files instead of an index,
+// for indexing all packages.
+func PackagesOnDisk(store Store) ([]Package, error) {
+	if lister, ok := store.(diskLister); ok {
+		return lister.PackagesOnDisk()
+	}
+	return store.Packages()
+}
+
+// GetJSONAsset unmarshals a package asset into v, v is left untouched if the
+// asset does not exist. Read v after the call returns, in a statement like
+// return v, GetJSONAsset(...) the order v is read in is unspecified and slices
+// may be returned before they are filled.
+func GetJSONAsset(store Store, pkg Package, name string, v any) error {
+	data, err := store.GetPackageAsset(pkg, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// PutJSONAsset marshals v and saves it as a package asset.
+func PutJSONAsset(store Store, pkg Package, name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "   ")
+	if err != nil {
+		return err
+	}
+	return store.PutPackageAsset(pkg, name, data)
 }
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"enpeeem/storage/storagetest"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// TestStores runs the same checks against every Store implementation so they
+// behave identically.
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"file": func(t *testing.T) Store {
+			dir := t.TempDir()
+			return NewFileStore(dir, dir)
+		},
+		"file with separate metadata": func(t *testing.T) Store {
+			return NewFileStore(t.TempDir(), t.TempDir())
+		},
+		"content addressed": func(t *testing.T) Store {
+			dir := t.TempDir()
+			return NewCASFileStore(dir, dir)
+		},
+		"file with sql index": func(t *testing.T) Store {
+			dir := t.TempDir()
+			idx, err := OpenSQLIndex(filepath.Join(t.TempDir(), "index.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { idx.Close() })
+			return NewFileStore(dir, dir).WithSQLIndex(idx)
+		},
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"layered": func(t *testing.T) Store {
+			return NewLayeredStore(NewMemoryStore(), NewMemoryStore())
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore)
+		})
+	}
+}
+
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	x := Package{Registry: "registry.npmjs.org", Name: "x"}
+	scoped := Package{Registry: "registry.npmjs.org", Scope: "@s", Name: "y"}
+	tarball := func(pkg Package, version string) (Tarball, []byte) {
+		data := storagetest.NewTarball(t, map[string]string{"package/package.json": fmt.Sprintf(`{"name":%q,"version":%q}`, pkg.FullName(), version)})
+		return NewTarball(pkg, fmt.Sprintf("%s-%s.tgz", pkg.Name, version)), data
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		store := newStore(t)
+		tb, _ := tarball(x, "1.0.0")
+		checks := map[string]error{}
+		_, checks["GetPackageMetadataRaw"] = store.GetPackageMetadataRaw(x)
+		_, checks["GetPackageMetadata"] = store.GetPackageMetadata(x)
+		_, checks["GetTarball"] = store.GetTarball(tb)
+		_, checks["StatTarball"] = store.StatTarball(tb)
+		_, checks["GetPackageAsset"] = store.GetPackageAsset(x, "dist-tags.json")
+		checks["QuarantineTarball"] = store.QuarantineTarball(tb)
+		checks["DeleteTarball"] = store.DeleteTarball(tb)
+		for method, err := range checks {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: expected %v but got %v", method, ErrNotFound, err)
+			}
+		}
+		if err := store.DeletePackage(x); err != nil {
+			t.Errorf("expected no error deleting a missing package but got %v", err)
+		}
+		tarballs, err := store.Tarballs(x)
+		if err != nil || len(tarballs) != 0 {
+			t.Errorf("expected no tarballs but got %v %v", tarballs, err)
+		}
+	})
+
+	t.Run("tarballs", func(t *testing.T) {
+		store := newStore(t)
+		for _, v := range []string{"2.0.0", "1.0.0"} {
+			tb, data := tarball(x, v)
+			if err := store.PutTarball(tb, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tb, data := tarball(x, "1.0.0")
+		got, err := store.GetTarball(tb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("expected the stored tarball but got %d bytes", len(got))
+		}
+		info, err := store.StatTarball(tb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != int64(len(data)) || info.ModTime.IsZero() {
+			t.Errorf("expected size %d and a modification time but got %v", len(data), info)
+		}
+		tarballs, err := store.Tarballs(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Tarball{NewTarball(x, "x-1.0.0.tgz"), NewTarball(x, "x-2.0.0.tgz")}
+		if !slices.Equal(tarballs, expected) {
+			t.Errorf("expected %v but got %v", expected, tarballs)
+		}
+
+		if err := store.QuarantineTarball(tb); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetTarball(tb); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v for a quarantined tarball but got %v", ErrNotFound, err)
+		}
+		tb, _ = tarball(x, "2.0.0")
+		if err := store.DeleteTarball(tb); err != nil {
+			t.Fatal(err)
+		}
+		if tarballs, _ := store.Tarballs(x); len(tarballs) != 0 {
+			t.Errorf("expected no tarballs but got %v", tarballs)
+		}
+	})
+
+	t.Run("metadata and assets", func(t *testing.T) {
+		store := newStore(t)
+		if err := store.PutPackage(x, []byte(`{"name":"x","versions":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+		pkmt, err := store.GetPackageMetadata(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkmt.Name != "x" {
+			t.Errorf("expected x but got %v", pkmt.Name)
+		}
+		data := []byte(`{"beta":"1.0.0"}`)
+		if err := store.PutPackageAsset(x, "dist-tags.json", data); err != nil {
+			t.Fatal(err)
+		}
+		// changing the slice after saving must not change the stored asset
+		data[2] = 'B'
+		got, err := store.GetPackageAsset(x, "dist-tags.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != `{"beta":"1.0.0"}` {
+			t.Errorf("expected the stored asset but got %s", got)
+		}
+	})
+
+	t.Run("packages", func(t *testing.T) {
+		store := newStore(t)
+		for _, pkg := range []Package{x, scoped} {
+			tb, data := tarball(pkg, "1.0.0")
+			if err := store.PutTarball(tb, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pkgs, err := store.Packages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkgs) != 2 || !slices.Contains(pkgs, x) || !slices.Contains(pkgs, scoped) {
+			t.Errorf("expected %v and %v but got %v", x, scoped, pkgs)
+		}
+		if _, err := store.Index(x); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeletePackage(x); err != nil {
+			t.Fatal(err)
+		}
+		pkgs, err = store.Packages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(pkgs, []Package{scoped}) {
+			t.Errorf("expected %v but got %v", []Package{scoped}, pkgs)
+		}
+		if _, err := store.GetPackageMetadata(x); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v but got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("index", func(t *testing.T) {
+		store := newStore(t)
+		for _, v := range []string{"1.0.0", "1.1.0", "2.0.0-beta.1"} {
+			tb, data := tarball(scoped, v)
+			if err := store.PutTarball(tb, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := PutDistTags(store, scoped, map[string]string{"beta": "2.0.0-beta.1"}); err != nil {
+			t.Fatal(err)
+		}
+		pkmt, err := store.Index(scoped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkmt.Versions) != 3 {
+			t.Errorf("expected 3 versions but got %v", pkmt.VersionList())
+		}
+		if pkmt.DistTags["latest"] != "1.1.0" || pkmt.DistTags["beta"] != "2.0.0-beta.1" {
+			t.Errorf("expected latest 1.1.0 and beta 2.0.0-beta.1 but got %v", pkmt.DistTags)
+		}
+
+		tb, _ := tarball(scoped, "1.1.0")
+		if err := store.DeleteTarball(tb); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Index(scoped); err != nil {
+			t.Fatal(err)
+		}
+		pkmt, err = store.GetPackageMetadata(scoped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, found := pkmt.Versions["1.1.0"]; found || len(pkmt.Versions) != 2 {
+			t.Errorf("expected 1.1.0 to be removed but got %v", pkmt.VersionList())
+		}
+		if pkmt.DistTags["latest"] != "1.0.0" {
+			t.Errorf("expected latest 1.0.0 but got %v", pkmt.DistTags)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		store := newStore(t)
+		wg := sync.WaitGroup{}
+		for i := range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tb, data := tarball(x, fmt.Sprintf("1.0.%d", i))
+				if err := store.PutTarball(tb, data); err != nil {
+					t.Error(err)
+				}
+				if _, err := store.GetTarball(tb); err != nil {
+					t.Error(err)
+				}
+				if _, err := store.Tarballs(x); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		pkmt, err := store.Index(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkmt.Versions) != 10 {
+			t.Errorf("expected 10 versions but got %v", pkmt.VersionList())
+		}
+	})
+}
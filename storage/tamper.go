@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dist holds the hashes of a version's tarball as found in the dist field of
+// package metadata.
+type Dist struct {
+	Integrity string `json:"integrity,omitempty"`
+	Shasum    string `json:"shasum,omitempty"`
+}
+
+// Matches returns false if d and other have a digest of the same algorithm that
+// differ. Integrity strings can contain multiple hashes and the shasum is
+// compared as sha1 digest. Dists without a common algorithm always match since
+// they can't be compared.
+func (d Dist) Matches(other Dist) bool {
+	others := other.digests()
+	for algo, digest := range d.digests() {
+		if o, found := others[algo]; found && o != digest {
+			return false
+		}
+	}
+	return true
+}
+
+// digests returns the base64 encoded digests of the dist keyed by algorithm.
+func (d Dist) digests() map[string]string {
+	digests := map[string]string{}
+	for _, sri := range strings.Fields(d.Integrity) {
+		algo, digest, found := strings.Cut(sri, "-")
+		if !found {
+			continue
+		}
+		// options after a question mark are not part of the digest
+		digest, _, _ = strings.Cut(digest, "?")
+		digests[algo] = digest
+	}
+	if _, found := digests["sha1"]; !found && d.Shasum != "" {
+		if _, digest, found := strings.Cut(ShasumIntegrity(d.Shasum), "-"); found {
+			digests["sha1"] = digest
+		}
+	}
+	return digests
+}
+
+// Tampering is a version for which the remote registry reports different hashes
+// than previously recorded or than the hashes of the stashed tarball.
+type Tampering struct {
+	Package  string    `json:"package"`
+	Version  string    `json:"version"`
+	Expected Dist      `json:"expected"`
+	Upstream Dist      `json:"upstream"`
+	Detected time.Time `json:"detected"`
+}
+
+// tamperMux serializes updates of the recorded dists and tampering records.
+var tamperMux sync.Mutex
+
+// GetRecordedDists returns the hashes first seen for each version of a package.
+func GetRecordedDists(store Store, pkg Package) (map[string]Dist, error) {
+	dists := map[string]Dist{}
+	err := GetJSONAsset(store, pkg, IntegrityAssetName, &dists)
+	return dists, err
+}
+
+// GetTampering returns the tampering detected for a package.
+func GetTampering(store Store, pkg Package) ([]Tampering, error) {
+	tampering := []Tampering{}
+	err := GetJSONAsset(store, pkg, TamperingAssetName, &tampering)
+	return tampering, err
+}
+
+// DetectTampering compares the hashes of each version in raw package metadata from
+// the remote registry with the hashes recorded earlier and with the hashes of stashed
+// tarballs. Hashes of versions not seen before are recorded if record is true, metadata
+// from a mirror isn't recorded so tarballs from mirrors are checked against the hashes
+// of the primary registry. Like publish times, hashes are only recorded for packages
+// with stashed tarballs so packages that are never stashed don't leave files behind.
+// All mismatches are returned together with the ones not
+// detected before, which are also saved for audit.
+func DetectTampering(store Store, pkg Package, data []byte, record bool) ([]Tampering, []Tampering, error) {
+	doc := struct {
+		Versions map[string]struct {
+			Dist Dist `json:"dist"`
+		} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	tarballs, err := store.Tarballs(pkg)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, nil, err
+	}
+	record = record && len(tarballs) > 0
+
+	tamperMux.Lock()
+	defer tamperMux.Unlock()
+	recorded, err := GetRecordedDists(store, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	stashed, err := store.GetPackageMetadata(pkg)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, nil, err
+	}
+	audit, err := GetTampering(store, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tampered, detected []Tampering
+	changed := false
+	for v, version := range doc.Versions {
+		upstream := version.Dist
+		local := stashed.Dist(v)
+		first, found := recorded[v]
+		if !found {
+			first = local
+			if first == (Dist{}) {
+				first = upstream
+			}
+			if record {
+				recorded[v] = first
+				changed = true
+			}
+		}
+		if first.Matches(upstream) && local.Matches(upstream) {
+			continue
+		}
+		// the stashed tarball is what will be served so it's hashes are expected
+		expected := local
+		if expected == (Dist{}) {
+			expected = first
+		}
+		t := Tampering{Package: pkg.FullName(), Version: v, Expected: expected, Upstream: upstream, Detected: time.Now().UTC()}
+		tampered = append(tampered, t)
+		if seen(audit, t) {
+			continue
+		}
+		slog.Warn("upstream integrity changed", "pkg", pkg.String(), "version", v, "expected", expected.Integrity, "upstream", upstream.Integrity)
+		audit = append(audit, t)
+		detected = append(detected, t)
+	}
+	if changed {
+		if err := PutJSONAsset(store, pkg, IntegrityAssetName, recorded); err != nil {
+			return tampered, detected, err
+		}
+	}
+	if len(detected) > 0 {
+		if err := PutJSONAsset(store, pkg, TamperingAssetName, audit); err != nil {
+			return tampered, detected, err
+		}
+	}
+	return tampered, detected, nil
+}
+
+// seen returns true if the same upstream hashes were already detected for the version.
+func seen(audit []Tampering, t Tampering) bool {
+	for _, a := range audit {
+		if a.Version == t.Version && a.Upstream == t.Upstream {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRecordedIntegrity returns an error if data doesn't match the hashes recorded
+// for the tarball version.
+func CheckRecordedIntegrity(store Store, tarball Tarball, data []byte) error {
+	recorded, err := GetRecordedDists(store, tarball.Package())
+	if err != nil {
+		return err
+	}
+	dist, found := recorded[tarball.Version()]
+	if !found {
+		return nil
+	}
+	if dist.Integrity != "" {
+		return CheckIntegrity(data, dist.Integrity)
+	}
+	if dist.Shasum != "" && Shasum(data) != dist.Shasum {
+		return fmt.Errorf("%w: expected shasum %s", ErrIntegrity, dist.Shasum)
+	}
+	return nil
+}
+
+// RestoreDists replaces the hashes of tampered versions in raw package metadata with
+// the expected hashes, so clients keep accepting the original tarballs.
+func RestoreDists(data []byte, tampered []Tampering) ([]byte, error) {
+	if len(tampered) == 0 {
+		return data, nil
+	}
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, err
+	}
+	versions := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc["versions"], &versions); err != nil {
+		return data, err
+	}
+	for _, t := range tampered {
+		version, found := versions[t.Version]
+		if !found {
+			continue
+		}
+		dist := map[string]interface{}{}
+		if raw, found := version["dist"]; found {
+			if err := json.Unmarshal(raw, &dist); err != nil {
+				return data, err
+			}
+		}
+		delete(dist, "integrity")
+		delete(dist, "shasum")
+		delete(dist, "signatures")
+		if t.Expected.Integrity != "" {
+			dist["integrity"] = t.Expected.Integrity
+		}
+		if t.Expected.Shasum != "" {
+			dist["shasum"] = t.Expected.Shasum
+		}
+		raw, err := json.Marshal(dist)
+		if err != nil {
+			return data, err
+		}
+		version["dist"] = raw
+	}
+	raw, err := json.Marshal(versions)
+	if err != nil {
+		return data, err
+	}
+	doc["versions"] = raw
+	return json.Marshal(doc)
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"encoding/json"
+	"enpeeem/storage/storagetest"
+	"fmt"
+	"testing"
+)
+
+func TestDetectTampering(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(dir, dir)
+	pkg := Package{Registry: "registry.npmjs.org", Scope: "", Name: "create-vite"}
+	original := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"name":"create-vite","version":"5.0.0"}`})
+
+	// hashes are not recorded for packages without stashed tarballs
+	unstashed := Package{Registry: "registry.npmjs.org", Scope: "", Name: "create-react-app"}
+	if _, _, err := DetectTampering(store, unstashed, []byte(`{"versions":{"1.0.0":{"dist":{"integrity":"sha512-one"}}}}`), true); err != nil {
+		t.Fatal(err)
+	}
+	if pkgs, err := PackagesOnDisk(store); err != nil || len(pkgs) != 0 {
+		t.Errorf("expected no packages on disk but got %v %v", pkgs, err)
+	}
+
+	if err := store.PutTarball(NewTarball(pkg, "create-vite-5.0.0.tgz"), original); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Index(pkg); err != nil {
+		t.Fatal(err)
+	}
+	upstream := func(integrity5, integrity6 string) []byte {
+		return []byte(fmt.Sprintf(`{"name":"create-vite","versions":{
+			"5.0.0":{"dist":{"integrity":%q,"tarball":"https://registry.npmjs.org/create-vite/-/create-vite-5.0.0.tgz"}},
+			"6.0.0":{"dist":{"integrity":%q}}
+		}}`, integrity5, integrity6))
+	}
+
+	type Test struct {
+		Name     string
+		Data     []byte
+		Tampered []string
+		Detected []string
+	}
+	tests := []Test{
+		{Name: "unchanged", Data: upstream(Integrity(original), "sha512-six"), Tampered: nil, Detected: nil},
+		{Name: "stashed changed", Data: upstream("sha512-other", "sha512-six"), Tampered: []string{"5.0.0"}, Detected: []string{"5.0.0"}},
+		{Name: "already detected", Data: upstream("sha512-other", "sha512-six"), Tampered: []string{"5.0.0"}, Detected: nil},
+		{Name: "recorded changed", Data: upstream(Integrity(original), "sha512-changed"), Tampered: []string{"6.0.0"}, Detected: []string{"6.0.0"}},
+	}
+	versions := func(tampering []Tampering) []string {
+		var vers []string
+		for _, t := range tampering {
+			vers = append(vers, t.Version)
+		}
+		return vers
+	}
+	for _, test := range tests {
+		tampered, detected, err := DetectTampering(store, pkg, test.Data, true)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		if fmt.Sprint(versions(tampered)) != fmt.Sprint(test.Tampered) {
+			t.Errorf("%s: expected tampered %v but got %v", test.Name, test.Tampered, versions(tampered))
+		}
+		if fmt.Sprint(versions(detected)) != fmt.Sprint(test.Detected) {
+			t.Errorf("%s: expected detected %v but got %v", test.Name, test.Detected, versions(detected))
+		}
+
+		restored, err := RestoreDists(test.Data, tampered)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		doc := struct {
+			Versions map[string]struct {
+				Dist map[string]string `json:"dist"`
+			} `json:"versions"`
+		}{}
+		if err := json.Unmarshal(restored, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if actual := doc.Versions["5.0.0"].Dist["integrity"]; actual != Integrity(original) {
+			t.Errorf("%s: expected integrity %s but got %s", test.Name, Integrity(original), actual)
+		}
+		if actual := doc.Versions["5.0.0"].Dist["tarball"]; actual == "" {
+			t.Errorf("%s: expected tarball URL to be kept", test.Name)
+		}
+		if actual := doc.Versions["6.0.0"].Dist["integrity"]; actual != "sha512-six" {
+			t.Errorf("%s: expected integrity sha512-six but got %s", test.Name, actual)
+		}
+	}
+
+	audit, err := GetTampering(store, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 2 {
+		t.Errorf("expected 2 audit records but got %d", len(audit))
+	}
+	if err := CheckRecordedIntegrity(store, NewTarball(pkg, "create-vite-5.0.0.tgz"), []byte("tampered")); err == nil {
+		t.Error("expected tampered tarball to fail recorded integrity")
+	}
+
+	// hashes from mirrors are compared but never recorded
+	mirror := []byte(`{"name":"create-vite","versions":{"7.0.0":{"dist":{"integrity":"sha512-seven"}}}}`)
+	if _, _, err := DetectTampering(store, pkg, mirror, false); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := GetRecordedDists(store, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := recorded["7.0.0"]; found {
+		t.Error("expected 7.0.0 from mirror not to be recorded")
+	}
+}
+
+func TestDistMatches(t *testing.T) {
+	a, b := []byte("a"), []byte("b")
+	sha1A := ShasumIntegrity(Shasum(a))
+	type Test struct {
+		Name     string
+		A        Dist
+		B        Dist
+		Expected bool
+	}
+	tests := []Test{
+		{"same integrity", Dist{Integrity: Integrity(a)}, Dist{Integrity: Integrity(a)}, true},
+		{"different integrity", Dist{Integrity: Integrity(a)}, Dist{Integrity: Integrity(b)}, false},
+		{"shasum and sha512 integrity", Dist{Shasum: Shasum(a)}, Dist{Integrity: Integrity(b)}, true},
+		{"shasum and sha1 integrity", Dist{Shasum: Shasum(a)}, Dist{Integrity: sha1A}, true},
+		{"different shasum", Dist{Shasum: Shasum(a), Integrity: Integrity(a)}, Dist{Shasum: Shasum(b)}, false},
+		{"multiple hashes", Dist{Integrity: sha1A + " " + Integrity(a)}, Dist{Integrity: Integrity(a)}, true},
+		{"multiple hashes differ", Dist{Integrity: sha1A + " " + Integrity(b)}, Dist{Integrity: Integrity(a), Shasum: Shasum(a)}, false},
+		{"empty", Dist{}, Dist{Integrity: Integrity(a)}, true},
+	}
+	for _, test := range tests {
+		if actual := test.A.Matches(test.B); actual != test.Expected {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Expected, actual)
+		}
+	}
+}
@@ -4,18 +4,29 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 )
 
 var ErrParseTarball = errors.New("parse error")
 
+var (
+	// MaxContentSize is the size of the largest file read by ContentsFromTar.
+	MaxContentSize int64 = 4 << 20
+	// MaxContentsSize is the total size of the files read by ContentsFromTar.
+	MaxContentsSize int64 = 64 << 20
+)
+
 type Tarball struct {
 	pkg  Package
 	Name string
@@ -71,20 +82,7 @@ func TarballFromURI(uri string) (Tarball, error) {
 }
 
 func (tarball Tarball) FetchRemotely() ([]byte, error) {
-	resp, err := http.Get(tarball.RemoteURL())
-	if err != nil {
-		return []byte{}, err
-	}
-
-	switch resp.StatusCode {
-	case http.StatusNotFound:
-		return []byte{}, ErrNotFound
-	case http.StatusOK:
-		defer resp.Body.Close()
-		return io.ReadAll(resp.Body)
-	default:
-		return []byte{}, fmt.Errorf("error calling %s responded with: %v %s", tarball.RemoteURL(), resp.StatusCode, resp.Status)
-	}
+	return fetchTarball(tarball)
 }
 
 func (tarball Tarball) Package() Package {
@@ -144,3 +142,141 @@ func (tarball Tarball) PackageJsonFromTar(tgz []byte) ([]byte, error) {
 		}
 	}
 }
+
+// walkTar calls fn for each entry in the gzipped tarball until fn returns false
+// or there are no more entries.
+func (tarball Tarball) walkTar(tgz []byte, fn func(hdr *tar.Header, r io.Reader) (bool, error)) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return fmt.Errorf("error in gzip reader opening %s: %w", tarball.String(), err)
+	}
+	tr := tar.NewReader(gzipReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", tarball.String(), err)
+		}
+		next, err := fn(hdr, tr)
+		if err != nil || !next {
+			return err
+		}
+	}
+}
+
+// ContainsFile returns true if the gzipped tarball has an entry matching the pattern.
+func (tarball Tarball) ContainsFile(tgz []byte, pattern string) (bool, error) {
+	found := false
+	err := tarball.walkTar(tgz, func(hdr *tar.Header, _ io.Reader) (bool, error) {
+		found, _ = filepath.Match(pattern, hdr.Name)
+		return !found, nil
+	})
+	return found, err
+}
+
+// ReadmeFromTar returns the name and content of the README in the package root of
+// the gzipped tarball, ErrNotFound if there is none.
+func (tarball Tarball) ReadmeFromTar(tgz []byte) (string, []byte, error) {
+	name, data := "", []byte{}
+	err := tarball.walkTar(tgz, func(hdr *tar.Header, r io.Reader) (bool, error) {
+		dir, file := path.Split(hdr.Name)
+		if strings.Count(dir, "/") != 1 || !strings.HasPrefix(strings.ToLower(file), "readme") || hdr.Typeflag != tar.TypeReg {
+			return true, nil
+		}
+		var err error
+		name = file
+		data, err = io.ReadAll(r)
+		return false, err
+	})
+	if err == nil && name == "" {
+		return name, data, ErrNotFound
+	}
+	return name, data, err
+}
+
+// TarFile is a regular file in a tarball, Path is relative to the package root
+// and starts with a slash.
+type TarFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// tarFile returns the file of a tar entry with the package root directory, which
+// is package in tarballs from npm, removed. It returns false for entries that are
+// not regular files.
+func tarFile(hdr *tar.Header) (TarFile, bool) {
+	if hdr.Typeflag != tar.TypeReg {
+		return TarFile{}, false
+	}
+	_, name, found := strings.Cut(path.Clean(strings.TrimPrefix(hdr.Name, "/")), "/")
+	if !found || name == "" {
+		return TarFile{}, false
+	}
+	return TarFile{Path: "/" + name, Size: hdr.Size, ModTime: hdr.ModTime}, true
+}
+
+// FilesFromTar returns the regular files in the gzipped tarball sorted by path.
+func (tarball Tarball) FilesFromTar(tgz []byte) ([]TarFile, error) {
+	files := []TarFile{}
+	err := tarball.walkTar(tgz, func(hdr *tar.Header, _ io.Reader) (bool, error) {
+		if file, ok := tarFile(hdr); ok {
+			files = append(files, file)
+		}
+		return true, nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, err
+}
+
+// FileFromTar calls fn with the file at name, relative to the package root, and a
+// reader of its content. It returns ErrNotFound if the gzipped tarball has no
+// regular file at name.
+func (tarball Tarball) FileFromTar(tgz []byte, name string, fn func(file TarFile, r io.Reader) error) error {
+	found := false
+	name = path.Clean("/" + name)
+	err := tarball.walkTar(tgz, func(hdr *tar.Header, r io.Reader) (bool, error) {
+		file, ok := tarFile(hdr)
+		if !ok || file.Path != name {
+			return true, nil
+		}
+		found = true
+		return false, fn(file, r)
+	})
+	if err == nil && !found {
+		return fmt.Errorf("%s not found in %s: %w", name, tarball.String(), ErrNotFound)
+	}
+	return err
+}
+
+// ContentsFromTar returns the content of the regular files in the gzipped tarball
+// by path, paths are like in FilesFromTar. Files larger than MaxContentSize, and
+// all files after MaxContentsSize bytes were read, are not kept in memory but
+// returned as oversized with the sha512 integrity of their content.
+func (tarball Tarball) ContentsFromTar(tgz []byte) (contents map[string][]byte, oversized map[string]string, err error) {
+	contents, oversized = map[string][]byte{}, map[string]string{}
+	total := int64(0)
+	err = tarball.walkTar(tgz, func(hdr *tar.Header, r io.Reader) (bool, error) {
+		file, ok := tarFile(hdr)
+		if !ok {
+			return true, nil
+		}
+		if file.Size > MaxContentSize || total+file.Size > MaxContentsSize {
+			h := sha512.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return false, err
+			}
+			oversized[file.Path] = "sha512-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+			return true, nil
+		}
+		data, err := io.ReadAll(r)
+		contents[file.Path] = data
+		total += int64(len(data))
+		return err == nil, err
+	})
+	return contents, oversized, err
+}
@@ -1,6 +1,10 @@
 package storage
 
 import (
+	"enpeeem/storage/storagetest"
+	"errors"
+	"io"
+	"slices"
 	"testing"
 	"text/template"
 )
@@ -206,3 +210,104 @@ func TestRewrittenURL(t *testing.T) {
 		}
 	}
 }
+
+func TestReadmeFromTar(t *testing.T) {
+	tarball := NewTarball(Package{Registry: "registry.npmjs.org", Name: "x"}, "x-1.0.0.tgz")
+	type Test struct {
+		Name     string
+		Files    map[string]string
+		Expected string
+		Err      error
+	}
+	tests := []Test{
+		{"root readme", map[string]string{"package/package.json": "{}", "package/README.md": "# x"}, "README.md", nil},
+		{"lowercase", map[string]string{"x/readme.markdown": "# x"}, "readme.markdown", nil},
+		{"nested only", map[string]string{"package/docs/README.md": "# docs"}, "", ErrNotFound},
+		{"none", map[string]string{"package/package.json": "{}"}, "", ErrNotFound},
+	}
+	for _, test := range tests {
+		name, data, err := tarball.ReadmeFromTar(storagetest.NewTarball(t, test.Files))
+		if !errors.Is(err, test.Err) {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Err, err)
+		}
+		if name != test.Expected {
+			t.Errorf("%s: expected %s but got %s", test.Name, test.Expected, name)
+		}
+		if test.Err == nil && string(data) != "# x" {
+			t.Errorf("%s: expected # x but got %s", test.Name, data)
+		}
+	}
+}
+
+func TestFilesFromTar(t *testing.T) {
+	tarball := NewTarball(Package{Registry: "registry.npmjs.org", Name: "x"}, "x-1.0.0.tgz")
+	tgz := storagetest.NewTarball(t, map[string]string{"package/package.json": "{}", "package/lib/index.js": "exports.x = 1", "package/README.md": "# x"})
+	files, err := tarball.FilesFromTar(tgz)
+	if err != nil {
+		t.Fatalf("expected nil but got %v", err)
+	}
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	expected := []string{"/README.md", "/lib/index.js", "/package.json"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected %v but got %v", expected, paths)
+	}
+	contents, oversized, err := tarball.ContentsFromTar(tgz)
+	if err != nil || len(contents) != 3 || len(oversized) != 0 || string(contents["/lib/index.js"]) != "exports.x = 1" {
+		t.Errorf("expected contents of 3 files but got %v %v %v", contents, oversized, err)
+	}
+	maxContentSize, maxContentsSize := MaxContentSize, MaxContentsSize
+	t.Cleanup(func() {
+		MaxContentSize, MaxContentsSize = maxContentSize, maxContentsSize
+	})
+	type SizeTest struct {
+		MaxContentSize  int64
+		MaxContentsSize int64
+		Oversized       []string
+	}
+	// files are read in the order of the tarball, sorted by path
+	for _, test := range []SizeTest{{2, 100, []string{"/README.md", "/lib/index.js"}}, {100, 10, []string{"/lib/index.js"}}} {
+		MaxContentSize, MaxContentsSize = test.MaxContentSize, test.MaxContentsSize
+		contents, oversized, err := tarball.ContentsFromTar(tgz)
+		if err != nil || len(contents)+len(oversized) != 3 || len(oversized) != len(test.Oversized) {
+			t.Errorf("%v: expected %v to be oversized but got %v %v", test, test.Oversized, oversized, err)
+		}
+		for _, name := range test.Oversized {
+			if _, found := contents[name]; found || oversized[name] == "" {
+				t.Errorf("%v: expected %s to be oversized but got %v", test, name, oversized)
+			}
+		}
+	}
+	if _, oversized, _ := tarball.ContentsFromTar(tgz); oversized["/lib/index.js"] != Integrity([]byte("exports.x = 1")) {
+		t.Errorf("expected the integrity of /lib/index.js but got %v", oversized)
+	}
+
+	type Test struct {
+		Name     string
+		Path     string
+		Expected string
+		Err      error
+	}
+	tests := []Test{
+		{"file", "/lib/index.js", "exports.x = 1", nil},
+		{"relative", "lib/../lib/index.js", "exports.x = 1", nil},
+		{"directory", "/lib", "", ErrNotFound},
+		{"missing", "/index.js", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		content := ""
+		err := tarball.FileFromTar(tgz, test.Path, func(file TarFile, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			content = string(data)
+			return err
+		})
+		if !errors.Is(err, test.Err) {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Err, err)
+		}
+		if content != test.Expected {
+			t.Errorf("%s: expected %s but got %s", test.Name, test.Expected, content)
+		}
+	}
+}
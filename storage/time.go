@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// TimeLayout is the timestamp format npm uses in the package metadata time field.
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// timesMux serializes updates of the times asset, which is read, changed and
+// written again.
+var timesMux sync.Mutex
+
+// GetTimes returns the publish times for a package keyed by version, as
+// reported by the remote registry.
+func GetTimes(store Store, pkg Package) (map[string]string, error) {
+	times := map[string]string{}
+	err := GetJSONAsset(store, pkg, TimeAssetName, &times)
+	return times, err
+}
+
+// PutTimes adds publish times reported by the remote registry to the times
+// saved for a package. Times are only written if there are new versions and the
+// package has stashed tarballs, so metadata requests for packages that are never
+// stashed don't leave files behind.
+func PutTimes(store Store, pkg Package, times map[string]string) error {
+	tarballs, err := store.Tarballs(pkg)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if len(tarballs) == 0 {
+		return nil
+	}
+	timesMux.Lock()
+	defer timesMux.Unlock()
+	saved, err := GetTimes(store, pkg)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for v, t := range times {
+		if v == "created" || v == "modified" {
+			continue
+		}
+		if saved[v] != t {
+			saved[v] = t
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return PutJSONAsset(store, pkg, TimeAssetName, saved)
+}
+
+// SetTimes updates the time field with the publish time for each version. Times
+// already in the metadata are kept unless found in published. If not found in
+// either the fallback function is used. Versions no longer in the metadata are
+// removed and the created and modified fields are set to the earliest and latest
+// publish time.
+func (pm *PackageMetadata) SetTimes(published map[string]string, fallback func(version string) time.Time) {
+	times := map[string]string{}
+	var created, modified time.Time
+	for v := range pm.Versions {
+		t, found := published[v]
+		if !found {
+			t, found = pm.Time[v]
+		}
+		if !found {
+			t = fallback(v).UTC().Format(TimeLayout)
+		}
+		times[v] = t
+		if pt, err := time.Parse(time.RFC3339, t); err == nil {
+			if created.IsZero() || pt.Before(created) {
+				created = pt
+			}
+			if pt.After(modified) {
+				modified = pt
+			}
+		}
+	}
+	if !created.IsZero() {
+		times["created"] = created.UTC().Format(TimeLayout)
+		times["modified"] = modified.UTC().Format(TimeLayout)
+	}
+	pm.Time = times
+}
+
+// PublishTime returns the publish time for a version, false is returned if
+// the time is not known.
+func (pm PackageMetadata) PublishTime(version string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, pm.Time[version])
+	if err != nil {
+		return t, false
+	}
+	return t, true
+}
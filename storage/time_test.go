@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTimes(t *testing.T) {
+	pkmt := NewPackageMetadata("", "create-vite", map[string]interface{}{"1.0.0": "", "2.0.0": "", "3.0.0": ""})
+	pkmt.Time = map[string]string{"2.0.0": "2022-01-01T00:00:00.000Z", "0.1.0": "2020-01-01T00:00:00.000Z"}
+	published := map[string]string{"1.0.0": "2021-01-01T00:00:00.000Z"}
+	fallback := func(string) time.Time {
+		return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	pkmt.SetTimes(published, fallback)
+
+	expected := map[string]string{
+		"1.0.0":    "2021-01-01T00:00:00.000Z",
+		"2.0.0":    "2022-01-01T00:00:00.000Z",
+		"3.0.0":    "2023-01-01T00:00:00.000Z",
+		"created":  "2021-01-01T00:00:00.000Z",
+		"modified": "2023-01-01T00:00:00.000Z",
+	}
+	if len(pkmt.Time) != len(expected) {
+		t.Errorf("expected %v times but found %v", len(expected), len(pkmt.Time))
+	}
+	for k, v := range expected {
+		if pkmt.Time[k] != v {
+			t.Errorf("expected time for %s to be %s but got %s", k, v, pkmt.Time[k])
+		}
+	}
+}
+
+func TestPutTimes(t *testing.T) {
+	store := NewMemoryStore()
+	pkg := Package{Registry: "registry.npmjs.org", Name: "create-vite"}
+	times := map[string]string{"1.0.0": "2021-01-01T00:00:00.000Z", "created": "2021-01-01T00:00:00.000Z"}
+	if err := PutTimes(store, pkg, times); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := GetTimes(store, pkg); len(saved) != 0 {
+		t.Errorf("expected no times for a package without tarballs but got %v", saved)
+	}
+
+	if err := store.PutTarball(NewTarball(pkg, "create-vite-1.0.0.tgz"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutTimes(store, pkg, times); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := GetTimes(store, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved["1.0.0"] != times["1.0.0"] {
+		t.Errorf("expected %v but got %v", map[string]string{"1.0.0": times["1.0.0"]}, saved)
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"enpeeem/upstream"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Upstream is the client used for all requests to remote registries.
+var Upstream = upstream.New(upstream.Options{})
+
+// Mirrors are tried in order of health for packages from the primary registry,
+// nil if there's only one registry.
+var Mirrors *upstream.Mirrors
+
+// FetchPackageMetadataFallback downloads package metadata from the remote registry
+// or a mirror, fallback is true if it's not from the primary registry. Tarball URLs
+// in metadata from mirrors are rewritten to the primary registry.
+func FetchPackageMetadataFallback(pkg Package) ([]byte, bool, error) {
+	if Mirrors == nil || pkg.Registry != Mirrors.Primary() {
+		data, err := fetchRemotely(pkg.RemoteURL())
+		return data, false, err
+	}
+	data, mirror, err := fetchMirrors(pkg, func(base string) string {
+		u, _ := url.JoinPath(base, pkg.Scope, pkg.Name)
+		return u
+	})
+	if err != nil || mirror.Primary {
+		return data, false, err
+	}
+	data, err = primaryTarballURLs(pkg, data)
+	return data, true, err
+}
+
+// fetchTarball downloads a tarball from the remote registry or a mirror.
+func fetchTarball(tarball Tarball) ([]byte, error) {
+	pkg := tarball.Package()
+	if Mirrors == nil || pkg.Registry != Mirrors.Primary() {
+		return fetchRemotely(tarball.RemoteURL())
+	}
+	data, _, err := fetchMirrors(pkg, func(base string) string {
+		u, _ := url.JoinPath(base, pkg.Scope, pkg.Name, "-", tarball.Name)
+		return u
+	})
+	return data, err
+}
+
+// fetchMirrors tries the mirrors until one responds, a missing package is not a
+// failure and isn't looked up in the next mirror.
+func fetchMirrors(pkg Package, remoteURL func(base string) string) ([]byte, upstream.Mirror, error) {
+	errs := []error{}
+	for _, mirror := range Mirrors.Order() {
+		start := time.Now()
+		data, err := fetchRemotely(remoteURL(mirror.URL))
+		if errors.Is(err, ErrNotFound) {
+			Mirrors.Record(mirror, time.Since(start), nil)
+			return data, mirror, err
+		}
+		Mirrors.Record(mirror, time.Since(start), err)
+		if err == nil {
+			if len(errs) > 0 || !mirror.Primary {
+				slog.Info("fetched from mirror", "pkg", pkg.String(), "mirror", mirror.URL)
+			}
+			return data, mirror, nil
+		}
+		slog.Warn("upstream failed, trying next mirror", "pkg", pkg.String(), "mirror", mirror.URL, "cause", err)
+		errs = append(errs, err)
+	}
+	return []byte{}, upstream.Mirror{}, errors.Join(errs...)
+}
+
+// primaryTarballURLs rewrites the tarball URLs in raw package metadata to the
+// primary registry, keeping everything else as is.
+func primaryTarballURLs(pkg Package, data []byte) ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, err
+	}
+	versions := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc["versions"], &versions); err != nil {
+		return data, err
+	}
+	for _, version := range versions {
+		dist := map[string]interface{}{}
+		if err := json.Unmarshal(version["dist"], &dist); err != nil {
+			continue
+		}
+		tarballURL, _ := dist["tarball"].(string)
+		if tarballURL == "" {
+			continue
+		}
+		dist["tarball"] = NewTarball(pkg, path.Base(tarballURL)).RemoteURL()
+		raw, err := json.Marshal(dist)
+		if err != nil {
+			return data, err
+		}
+		version["dist"] = raw
+	}
+	raw, err := json.Marshal(versions)
+	if err != nil {
+		return data, err
+	}
+	doc["versions"] = raw
+	return json.Marshal(doc)
+}
+
+func fetchRemotely(url string) ([]byte, error) {
+	resp, err := Upstream.Get(url)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return []byte{}, ErrNotFound
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	default:
+		return []byte{}, fmt.Errorf("error calling %s responded with: %v %s", url, resp.StatusCode, resp.Status)
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"encoding/json"
+	"enpeeem/upstream"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchMirrors(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	var mirror *httptest.Server
+	mirror = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/npm/x":
+			w.Write([]byte(`{"name":"x","versions":{"1.0.0":{"dist":{"tarball":"` + mirror.URL + `/npm/x/-/x-1.0.0.tgz","shasum":"abc"}}}}`))
+		case "/npm/x/-/x-1.0.0.tgz":
+			w.Write([]byte("tarball"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	defer func(client *upstream.Client) { Upstream, Mirrors = client, nil }(Upstream)
+	Upstream = upstream.New(upstream.Options{MaxAttempts: 1})
+	var err error
+	if Mirrors, err = upstream.NewMirrors(Upstream, []string{primary.URL, mirror.URL + "/npm"}, false); err != nil {
+		t.Fatal(err)
+	}
+	pkg, _ := NewPackage(primary.URL, "", "x")
+
+	data, fallback, err := FetchPackageMetadataFallback(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fallback {
+		t.Error("expected metadata from fallback")
+	}
+	pkmt := struct {
+		Versions map[string]struct {
+			Dist map[string]string `json:"dist"`
+		} `json:"versions"`
+	}{}
+	if err := json.Unmarshal(data, &pkmt); err != nil {
+		t.Fatal(err)
+	}
+	tarball := NewTarball(pkg, "x-1.0.0.tgz")
+	if actual := pkmt.Versions["1.0.0"].Dist["tarball"]; actual != tarball.RemoteURL() {
+		t.Errorf("expected %s but got %s", tarball.RemoteURL(), actual)
+	}
+	if actual := pkmt.Versions["1.0.0"].Dist["shasum"]; actual != "abc" {
+		t.Errorf("expected abc but got %s", actual)
+	}
+	if data, err := tarball.FetchRemotely(); err != nil || string(data) != "tarball" {
+		t.Errorf("expected tarball from mirror but got %s %v", data, err)
+	}
+	missing, _ := NewPackage(primary.URL, "", "y")
+	if _, _, err := FetchPackageMetadataFallback(missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrCorrupt   = errors.New("corrupt tarball")
+	ErrIntegrity = errors.New("integrity mismatch")
+)
+
+type VerifyOptions struct {
+	// Upstream enables fetching package metadata from the remote registry if
+	// the local metadata has no integrity for a tarball.
+	Upstream bool
+	// Quarantine moves tarballs failing verification out of the way.
+	Quarantine bool
+	// Refetch downloads failing tarballs again from the remote registry.
+	Refetch bool
+}
+
+type VerifyResult struct {
+	Tarball     string `json:"tarball"`
+	Error       string `json:"error,omitempty"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+	Refetched   bool   `json:"refetched,omitempty"`
+}
+
+// Integrity returns the sha512 subresource integrity string for data, the
+// same format npm uses for dist.integrity.
+func Integrity(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Shasum returns the hex encoded sha1 checksum for data, the same format npm
+// uses for dist.shasum.
+func Shasum(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ShasumIntegrity converts a hex encoded sha1 shasum to a subresource integrity string.
+func ShasumIntegrity(shasum string) string {
+	raw, err := hex.DecodeString(shasum)
+	if err != nil {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// integrityAlgorithms lists the supported subresource integrity algorithms
+// from the weakest to the strongest.
+var integrityAlgorithms = []string{"sha1", "sha256", "sha384", "sha512"}
+
+// CheckIntegrity validates data against a subresource integrity string. Integrity
+// strings can contain multiple hashes separated by space, like ssri only the
+// strongest algorithm present is compared so a weaker hash cannot vouch for
+// data the stronger one rejects.
+func CheckIntegrity(data []byte, integrity string) error {
+	strongest := -1
+	var expected []string
+	for _, sri := range strings.Fields(integrity) {
+		algo, digest, found := strings.Cut(sri, "-")
+		if !found {
+			continue
+		}
+		rank := slices.Index(integrityAlgorithms, algo)
+		if rank < 0 || rank < strongest {
+			continue
+		}
+		if rank > strongest {
+			strongest, expected = rank, nil
+		}
+		expected = append(expected, digest)
+	}
+	if strongest >= 0 {
+		var h hash.Hash
+		switch integrityAlgorithms[strongest] {
+		case "sha512":
+			h = sha512.New()
+		case "sha384":
+			h = sha512.New384()
+		case "sha256":
+			h = sha256.New()
+		case "sha1":
+			h = sha1.New()
+		}
+		h.Write(data)
+		if slices.Contains(expected, base64.StdEncoding.EncodeToString(h.Sum(nil))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: expected %s", ErrIntegrity, integrity)
+}
+
+// VerifyTarball validates that data is a readable gzipped tarball containing
+// a package.json with a version matching the tarball filename. If integrity is
+// not empty data must also match it.
+func VerifyTarball(tarball Tarball, data []byte, integrity string) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrCorrupt, tarball.String(), err)
+	}
+	var pkgJson []byte
+	tr := tar.NewReader(gzipReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCorrupt, tarball.String(), err)
+		}
+		matched, _ := filepath.Match("*/package.json", hdr.Name)
+		if matched && pkgJson == nil {
+			if pkgJson, err = io.ReadAll(tr); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrCorrupt, tarball.String(), err)
+			}
+			continue
+		}
+		// read all file content to make sure the entire archive is intact
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCorrupt, tarball.String(), err)
+		}
+	}
+	if pkgJson == nil {
+		return fmt.Errorf("%w: %s: package.json not found", ErrCorrupt, tarball.String())
+	}
+	raw := struct {
+		Version string `json:"version"`
+	}{}
+	if err := json.Unmarshal(pkgJson, &raw); err != nil {
+		return fmt.Errorf("%w: %s: invalid package.json: %w", ErrCorrupt, tarball.String(), err)
+	}
+	if raw.Version != tarball.Version() {
+		return fmt.Errorf("%w: %s: package.json version %s does not match filename", ErrCorrupt, tarball.String(), raw.Version)
+	}
+	if integrity != "" {
+		if err := CheckIntegrity(data, integrity); err != nil {
+			return fmt.Errorf("%s: %w", tarball.String(), err)
+		}
+	}
+	return nil
+}
+
+// Verify validates all tarballs for a package and returns the result for those
+// failing verification. The expected integrity is read from the local package
+// metadata, or from the remote registry if enabled in opts. Tarballs failing
+// verification are quarantined and refetched depending on opts. Package metadata
+// is reindexed if any tarball was changed.
+func Verify(store Store, pkg Package, opts VerifyOptions) ([]VerifyResult, error) {
+	results := []VerifyResult{}
+	tarballs, err := store.Tarballs(pkg)
+	if err != nil {
+		return results, err
+	}
+	pkmt, err := store.GetPackageMetadata(pkg)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return results, err
+	}
+	var remote *PackageMetadata
+	changed := false
+	for _, tarball := range tarballs {
+		result := VerifyResult{Tarball: tarball.String()}
+		integrity := pkmt.Integrity(tarball.Version())
+		if integrity == "" && opts.Upstream {
+			if remote == nil {
+				remote = &PackageMetadata{}
+				if data, err := FetchPackageMetadataRemotely(pkg); err != nil {
+					slog.Warn("could not fetch remote package metadata", "pkg", pkg.String(), "error", err)
+				} else if err := json.Unmarshal(data, remote); err != nil {
+					slog.Warn("could not parse remote package metadata", "pkg", pkg.String(), "error", err)
+				}
+			}
+			integrity = remote.Integrity(tarball.Version())
+		}
+		data, err := store.GetTarball(tarball)
+		if err == nil {
+			err = VerifyTarball(tarball, data, integrity)
+		}
+		if err == nil {
+			continue
+		}
+		result.Error = err.Error()
+		slog.Warn("tarball failed verification", "tarball", tarball.String(), "error", err)
+		if opts.Quarantine || opts.Refetch {
+			if err := store.QuarantineTarball(tarball); err != nil {
+				return results, err
+			}
+			result.Quarantined = true
+			changed = true
+		}
+		if opts.Refetch {
+			if err := refetch(store, tarball, integrity); err != nil {
+				slog.Error("could not refetch tarball", "tarball", tarball.String(), "error", err)
+			} else {
+				result.Refetched = true
+			}
+		}
+		results = append(results, result)
+	}
+	if changed {
+		if _, err := store.Index(pkg); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func refetch(store Store, tarball Tarball, integrity string) error {
+	data, err := tarball.FetchRemotely()
+	if err != nil {
+		return err
+	}
+	if err := VerifyTarball(tarball, data, integrity); err != nil {
+		return err
+	}
+	return store.PutTarball(tarball, data)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"enpeeem/storage/storagetest"
+	"errors"
+	"testing"
+)
+
+func TestVerifyTarball(t *testing.T) {
+	pkg := Package{Registry: "registry.npmjs.org", Scope: "", Name: "create-vite"}
+	tarball := NewTarball(pkg, "create-vite-5.0.0.tgz")
+	valid := storagetest.NewTarball(t, map[string]string{"package/package.json": `{"name":"create-vite","version":"5.0.0"}`})
+
+	type Test struct {
+		Name      string
+		Data      []byte
+		Integrity string
+		Expected  error
+	}
+	tests := []Test{
+		{Name: "valid", Data: valid, Integrity: "", Expected: nil},
+		{Name: "valid sha512", Data: valid, Integrity: Integrity(valid), Expected: nil},
+		{Name: "valid sha1", Data: valid, Integrity: ShasumIntegrity(Shasum(valid)), Expected: nil},
+		{Name: "valid sha1 and sha512", Data: valid, Integrity: ShasumIntegrity(Shasum(valid)) + " " + Integrity(valid), Expected: nil},
+		{Name: "valid sha1 wrong sha512", Data: valid, Integrity: ShasumIntegrity(Shasum(valid)) + " " + Integrity([]byte("other")), Expected: ErrIntegrity},
+		{Name: "wrong integrity", Data: valid, Integrity: Integrity([]byte("other")), Expected: ErrIntegrity},
+		{Name: "truncated", Data: valid[:len(valid)/2], Integrity: "", Expected: ErrCorrupt},
+		{Name: "not gzip", Data: []byte("not a tarball"), Integrity: "", Expected: ErrCorrupt},
+		{Name: "no package.json", Data: storagetest.NewTarball(t, map[string]string{"package/index.js": ""}), Integrity: "", Expected: ErrCorrupt},
+		{Name: "wrong version", Data: storagetest.NewTarball(t, map[string]string{"package/package.json": `{"version":"4.0.0"}`}), Integrity: "", Expected: ErrCorrupt},
+	}
+
+	for _, test := range tests {
+		actual := VerifyTarball(tarball, test.Data, test.Integrity)
+		if test.Expected == nil && actual != nil {
+			t.Errorf("%s: expected no error but got %v", test.Name, actual)
+		}
+		if test.Expected != nil && !errors.Is(actual, test.Expected) {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package upstream
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthy is the minimum score of a mirror tried before unhealthy mirrors.
+	healthy = 0.5
+	// decay is the weight of the previous score and latency when recording a request.
+	decay = 0.8
+	// recovery is the time after which half of the score lost to failures is
+	// regained, so demoted mirrors are tried again once the failures are old.
+	recovery = 5 * time.Minute
+)
+
+// Mirror is the health of an upstream registry.
+type Mirror struct {
+	URL      string  `json:"url"`
+	Primary  bool    `json:"primary"`
+	Score    float64 `json:"score"`
+	Latency  int64   `json:"latencyMs"`
+	Requests int64   `json:"requests"`
+	Failures int64   `json:"failures"`
+	// Open is true while the circuit breaker of the host is open.
+	Open      bool   `json:"open"`
+	LastError string `json:"lastError,omitempty"`
+
+	host    string
+	index   int
+	latency time.Duration
+	updated time.Time
+}
+
+// Mirrors is an ordered list of equivalent upstream registries, the first is the
+// primary. Requests are sent to healthy mirrors first, in the configured order or
+// by latency.
+type Mirrors struct {
+	client        *Client
+	preferLatency bool
+	mux           sync.Mutex
+	mirrors       []*Mirror
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewMirrors returns mirrors for the registry URLs, the first URL is the primary.
+func NewMirrors(client *Client, urls []string, preferLatency bool) (*Mirrors, error) {
+	m := &Mirrors{client: client, preferLatency: preferLatency, now: time.Now}
+	for i, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid registry URL %s", u)
+		}
+		m.mirrors = append(m.mirrors, &Mirror{URL: strings.TrimSuffix(u, "/"), Primary: i == 0, Score: 1, host: parsed.Host, index: i})
+	}
+	if len(m.mirrors) == 0 {
+		return nil, fmt.Errorf("no registry URLs")
+	}
+	return m, nil
+}
+
+// Primary returns the host of the primary registry.
+func (m *Mirrors) Primary() string {
+	return m.mirrors[0].host
+}
+
+// Order returns the mirrors in the order they should be tried.
+func (m *Mirrors) Order() []Mirror {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	open := m.open()
+	now := m.now()
+	mirrors := []Mirror{}
+	for _, mirror := range m.mirrors {
+		mirror.recover(now)
+		c := *mirror
+		c.Open = open[c.host]
+		mirrors = append(mirrors, c)
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		a, b := mirrors[i], mirrors[j]
+		if a.healthy() != b.healthy() {
+			return a.healthy()
+		}
+		if m.preferLatency {
+			// unmeasured mirrors are tried first to learn their latency
+			if (a.latency == 0) != (b.latency == 0) {
+				return a.latency == 0
+			}
+			if a.latency != b.latency {
+				return a.latency < b.latency
+			}
+		}
+		return a.index < b.index
+	})
+	return mirrors
+}
+
+// Record updates the score and latency of a mirror with the outcome of a request.
+func (m *Mirrors) Record(mirror Mirror, latency time.Duration, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	current := m.mirrors[mirror.index]
+	current.recover(m.now())
+	current.Requests++
+	if err != nil {
+		current.Failures++
+		current.Score = current.Score * decay
+		current.LastError = err.Error()
+		return
+	}
+	current.Score = current.Score*decay + 1 - decay
+	if current.latency == 0 {
+		current.latency = latency
+	} else {
+		current.latency = time.Duration(float64(current.latency)*decay + float64(latency)*(1-decay))
+	}
+	current.Latency = current.latency.Milliseconds()
+}
+
+// Status returns the health of all mirrors in the configured order.
+func (m *Mirrors) Status() []Mirror {
+	mirrors := m.Order()
+	slices.SortFunc(mirrors, func(a, b Mirror) int {
+		return a.index - b.index
+	})
+	return mirrors
+}
+
+// open returns the hosts with an open circuit breaker.
+func (m *Mirrors) open() map[string]bool {
+	open := map[string]bool{}
+	if m.client == nil {
+		return open
+	}
+	for _, host := range m.client.Hosts() {
+		open[host.Host] = host.State == StateOpen
+	}
+	return open
+}
+
+// recover moves the score towards 1 for the time passed since it was last updated.
+func (mirror *Mirror) recover(now time.Time) {
+	if !mirror.updated.IsZero() && now.After(mirror.updated) {
+		lost := (1 - mirror.Score) * math.Pow(0.5, float64(now.Sub(mirror.updated))/float64(recovery))
+		mirror.Score = 1 - lost
+	}
+	mirror.updated = now
+}
+
+func (mirror Mirror) healthy() bool {
+	return !mirror.Open && mirror.Score >= healthy
+}
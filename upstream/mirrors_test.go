@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMirrorOrder(t *testing.T) {
+	type Test struct {
+		Test          string
+		PreferLatency bool
+		Failures      map[string]int
+		Latency       map[string]time.Duration
+		Elapsed       time.Duration
+		Expected      []string
+	}
+	urls := []string{"https://registry.npmjs.org", "https://artifactory.example.com/api/npm/npm", "https://registry.npmmirror.com"}
+	tests := []Test{
+		{Test: "configured order", Expected: urls},
+		{Test: "unhealthy primary", Failures: map[string]int{urls[0]: 4}, Expected: []string{urls[1], urls[2], urls[0]}},
+		{Test: "recovered primary", Failures: map[string]int{urls[0]: 4}, Elapsed: recovery, Expected: urls},
+		{Test: "single failure", Failures: map[string]int{urls[0]: 1}, Expected: urls},
+		{Test: "latency ignored", Latency: map[string]time.Duration{urls[0]: time.Second, urls[2]: time.Millisecond}, Expected: urls},
+		{Test: "prefer latency", PreferLatency: true, Latency: map[string]time.Duration{urls[0]: time.Second, urls[1]: 100 * time.Millisecond, urls[2]: time.Millisecond}, Expected: []string{urls[2], urls[1], urls[0]}},
+		{Test: "unmeasured first", PreferLatency: true, Latency: map[string]time.Duration{urls[0]: time.Second, urls[2]: time.Millisecond}, Expected: []string{urls[1], urls[2], urls[0]}},
+	}
+	for _, test := range tests {
+		m, err := NewMirrors(nil, urls, test.PreferLatency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		m.now = func() time.Time { return now }
+		for _, mirror := range m.Order() {
+			for i := 0; i < test.Failures[mirror.URL]; i++ {
+				m.Record(mirror, 0, errors.New("bad gateway"))
+			}
+			if latency, found := test.Latency[mirror.URL]; found {
+				m.Record(mirror, latency, nil)
+			}
+		}
+		now = now.Add(test.Elapsed)
+		actual := []string{}
+		for _, mirror := range m.Order() {
+			actual = append(actual, mirror.URL)
+		}
+		if len(actual) != len(test.Expected) {
+			t.Fatalf("%s: expected %v but got %v", test.Test, test.Expected, actual)
+		}
+		for i := range actual {
+			if actual[i] != test.Expected[i] {
+				t.Errorf("%s: expected %v but got %v", test.Test, test.Expected, actual)
+				break
+			}
+		}
+	}
+}
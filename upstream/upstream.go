@@ -0,0 +1,334 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned without calling the upstream while it's circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker open")
+
+// Options configure retries, concurrency and circuit breaking, zero values are
+// replaced by defaults.
+type Options struct {
+	// MaxAttempts is the number of attempts for GET requests, including the first.
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// MaxPerHost limits the number of concurrent requests to a host.
+	MaxPerHost int
+	// FailureThreshold is the number of consecutive failed requests opening the
+	// circuit breaker of a host.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before a request is let
+	// through to probe the upstream.
+	OpenTimeout time.Duration
+	Timeout     time.Duration
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 500 * time.Millisecond
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = 30 * time.Second
+	}
+	if opts.MaxPerHost <= 0 {
+		opts.MaxPerHost = 16
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+	return opts
+}
+
+// Host is the status of requests to an upstream host.
+type Host struct {
+	Host                string     `json:"host"`
+	State               string     `json:"state"`
+	Active              int        `json:"active"`
+	Requests            int64      `json:"requests"`
+	Retries             int64      `json:"retries"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	OpenUntil           *time.Time `json:"openUntil,omitempty"`
+
+	slots   chan struct{}
+	probing bool
+}
+
+// Client sends requests to upstream registries, retrying idempotent requests
+// with backoff and failing fast while an upstream is down.
+type Client struct {
+	opts   Options
+	client *http.Client
+	mux    sync.Mutex
+	hosts  map[string]*Host
+	// sleep is replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a client using opts.
+func New(opts Options) *Client {
+	opts = opts.withDefaults()
+	return &Client{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		hosts:  map[string]*Host{},
+		sleep:  sleep,
+	}
+}
+
+// Get sends a GET request to url.
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends a request, GET and HEAD requests are retried on network errors, 429 and
+// 5xx responses. The last response is returned if all attempts fail, it's the
+// callers responsibility to check the status.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	host := c.host(req.URL.Host)
+	attempts := 1
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		attempts = c.opts.MaxAttempts
+	}
+	delay := c.opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		probe, err := c.allow(host)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.send(req, host)
+		failed := err != nil || retryable(res.StatusCode)
+		// a failed probe opens the breaker again without retrying
+		if !failed || attempt >= attempts || probe {
+			c.record(host, res, err)
+			return res, err
+		}
+
+		wait := jitter(delay)
+		if res != nil {
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		if wait > c.opts.MaxRetryDelay {
+			c.record(host, nil, fmt.Errorf("retry after %s exceeds the maximum retry delay", wait))
+			return nil, fmt.Errorf("upstream %s asked to retry after %s", host.Host, wait)
+		}
+		c.mux.Lock()
+		host.Retries++
+		c.mux.Unlock()
+		slog.Debug("retrying upstream request", "url", req.URL, "attempt", attempt, "retry", wait, "cause", describe(res, err))
+		if err := c.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		delay = min(delay*2, c.opts.MaxRetryDelay)
+	}
+}
+
+// Hosts returns the status of all upstream hosts sorted by name.
+func (c *Client) Hosts() []Host {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	hosts := []Host{}
+	for _, host := range c.hosts {
+		h := *host
+		h.slots = nil
+		h.State = c.state(host, time.Now())
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts
+}
+
+// Reset closes the circuit breaker of a host.
+func (c *Client) Reset(name string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	host, found := c.hosts[name]
+	if found {
+		host.ConsecutiveFailures, host.OpenUntil, host.probing = 0, nil, false
+	}
+	return found
+}
+
+func (c *Client) host(name string) *Host {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	host, found := c.hosts[name]
+	if !found {
+		host = &Host{Host: name, slots: make(chan struct{}, c.opts.MaxPerHost)}
+		c.hosts[name] = host
+	}
+	return host
+}
+
+// state returns the breaker state, c.mux must be held.
+func (c *Client) state(host *Host, now time.Time) string {
+	if host.OpenUntil == nil {
+		return StateClosed
+	}
+	if now.Before(*host.OpenUntil) {
+		return StateOpen
+	}
+	return StateHalfOpen
+}
+
+// allow returns ErrCircuitOpen if the breaker is open, only one request at a time
+// probes a half open upstream. Returns true if the request is the probe.
+func (c *Client) allow(host *Host) (bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch c.state(host, time.Now()) {
+	case StateOpen:
+		return false, fmt.Errorf("%w for %s until %s", ErrCircuitOpen, host.Host, host.OpenUntil.Format(time.RFC3339))
+	case StateHalfOpen:
+		if host.probing {
+			return false, fmt.Errorf("%w for %s, waiting for probe", ErrCircuitOpen, host.Host)
+		}
+		host.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// send sends a single request while holding a slot of the host, the slot is
+// released when the response body is closed.
+func (c *Client) send(req *http.Request, host *Host) (*http.Response, error) {
+	select {
+	case host.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	c.mux.Lock()
+	host.Active++
+	host.Requests++
+	c.mux.Unlock()
+	release := sync.OnceFunc(func() {
+		c.mux.Lock()
+		host.Active--
+		c.mux.Unlock()
+		<-host.slots
+	})
+	res, err := c.client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &releaser{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// record updates the breaker with the outcome of a request.
+func (c *Client) record(host *Host, res *http.Response, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	host.probing = false
+	if err == nil && !retryable(res.StatusCode) {
+		if host.OpenUntil != nil {
+			slog.Info("upstream recovered, closing circuit breaker", "host", host.Host)
+		}
+		host.ConsecutiveFailures, host.OpenUntil = 0, nil
+		return
+	}
+	now := time.Now().UTC()
+	host.Failures++
+	host.ConsecutiveFailures++
+	host.LastError = describe(res, err)
+	host.LastFailure = &now
+	if host.ConsecutiveFailures >= c.opts.FailureThreshold {
+		until := now.Add(c.opts.OpenTimeout)
+		if host.OpenUntil == nil {
+			slog.Warn("upstream failing, opening circuit breaker", "host", host.Host, "until", until, "cause", host.LastError)
+		}
+		host.OpenUntil = &until
+	}
+}
+
+type releaser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func describe(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return res.Status
+}
+
+// jitter returns a random delay between half and all of d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as a HTTP date.
+func parseRetryAfter(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(s); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
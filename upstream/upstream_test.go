@@ -0,0 +1,135 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	type Test struct {
+		Test      string
+		Responses []int
+		Expected  int
+		Requests  int
+	}
+	tests := []Test{
+		{Test: "ok", Responses: []int{200}, Expected: 200, Requests: 1},
+		{Test: "not found isn't retried", Responses: []int{404}, Expected: 404, Requests: 1},
+		{Test: "bad gateway", Responses: []int{502, 200}, Expected: 200, Requests: 2},
+		{Test: "too many requests", Responses: []int{429, 503, 200}, Expected: 200, Requests: 3},
+		{Test: "all attempts fail", Responses: []int{500, 502, 503, 200}, Expected: 503, Requests: 3},
+	}
+	for _, test := range tests {
+		requests := atomic.Int32{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := requests.Add(1)
+			status := test.Responses[n-1]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "7")
+			}
+			w.WriteHeader(status)
+		}))
+		c := New(Options{})
+		waits := []time.Duration{}
+		c.sleep = func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}
+		res, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		server.Close()
+		if res.StatusCode != test.Expected {
+			t.Errorf("%s: expected %v but got %v", test.Test, test.Expected, res.StatusCode)
+		}
+		if int(requests.Load()) != test.Requests {
+			t.Errorf("%s: expected %v requests but got %v", test.Test, test.Requests, requests.Load())
+		}
+		if test.Responses[0] == http.StatusTooManyRequests && waits[0] != 7*time.Second {
+			t.Errorf("%s: expected retry after 7s but got %v", test.Test, waits[0])
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	down := atomic.Bool{}
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	c := New(Options{MaxAttempts: 1, FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if _, err := c.Get(server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected %v but got %v", ErrCircuitOpen, err)
+	}
+	if hosts := c.Hosts(); len(hosts) != 1 || hosts[0].State != StateOpen || hosts[0].Requests != 2 {
+		t.Errorf("expected open breaker after 2 requests but got %+v", hosts)
+	}
+
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if state := c.Hosts()[0].State; state != StateHalfOpen {
+		t.Errorf("expected %v but got %v", StateHalfOpen, state)
+	}
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if state := c.Hosts()[0].State; state != StateClosed {
+		t.Errorf("expected %v but got %v", StateClosed, state)
+	}
+}
+
+func TestMaxPerHost(t *testing.T) {
+	active, peak := atomic.Int32{}, atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		active.Add(-1)
+	}))
+	defer server.Close()
+	c := New(Options{MaxPerHost: 2})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 concurrent requests but got %d", peak.Load())
+	}
+}